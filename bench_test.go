@@ -4,6 +4,7 @@ package luar
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/aarzilli/golua/lua"
@@ -51,6 +52,23 @@ func BenchmarkLuaToGoSliceMapUnique(b *testing.B) {
 	}
 }
 
+func BenchmarkLuaToGoStreamInt(b *testing.B) {
+	L := Init()
+	defer L.Close()
+
+	sum := int64(0)
+	add := func(v reflect.Value) error {
+		sum += v.Int()
+		return nil
+	}
+	L.DoString(`t={}; for i = 1,100 do t[i]=i; end`)
+	L.GetGlobal("t")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		LuaToGoStream(L, -1, reflect.TypeOf(0), add)
+	}
+}
+
 func BenchmarkLuaToGoMapInt(b *testing.B) {
 	L := Init()
 	defer L.Close()
@@ -295,3 +313,40 @@ func BenchmarkLuarIpairs(b *testing.B) {
 		_ = L.DoString("luar_ipairs_test()")
 	}
 }
+
+func BenchmarkProxyStructField(b *testing.B) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{"p": &person{Name: "foo", Age: 17}})
+	L.DoString(`function field_test()
+	local tmp
+	for i = 1, 1000 do
+		tmp = p.Name
+		p.Age = i
+	end
+end`)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = L.DoString("field_test()")
+	}
+}
+
+func BenchmarkProxyStructMethod(b *testing.B) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{"p": &person{Name: "foo", Age: 17}})
+	L.DoString(`function method_test()
+	local tmp
+	for i = 1, 1000 do
+		tmp = p.GetName()
+	end
+end`)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = L.DoString("method_test()")
+	}
+}
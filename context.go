@@ -0,0 +1,49 @@
+package luar
+
+import (
+	"context"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// ContextCheckInterval is the number of Lua instructions run between two checks
+// of the context in DoStringContext.
+var ContextCheckInterval = 1000
+
+// DoStringContext is like L.DoString but aborts the execution of 'code' when
+// 'ctx' is done, in which case ctx.Err() is returned.
+//
+// The context is checked every ContextCheckInterval instructions by a Lua debug
+// hook, which is removed when DoStringContext returns. It replaces any hook
+// previously set on 'L', e.g. by SetExecutionLimit. Go functions called from
+// the script are not interrupted.
+//
+// Once the context is done, the error is raised again on every instruction, so
+// that scripts cannot carry on by catching it with 'pcall'.
+func DoStringContext(L *lua.State, ctx context.Context, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var hook func(L *lua.State)
+	aborted := false
+	hook = func(L *lua.State) {
+		err := ctx.Err()
+		if err == nil {
+			return
+		}
+		if !aborted {
+			aborted = true
+			L.SetHook(hook, 1)
+		}
+		L.RaiseError(err.Error())
+	}
+	L.SetHook(hook, ContextCheckInterval)
+	defer L.SetHook(nil, 0)
+
+	err := L.DoString(code)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+package luar
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// converter holds the conversion functions registered for a type.
+type converter struct {
+	to   func(L *lua.State, v reflect.Value)
+	from func(L *lua.State, idx int) (reflect.Value, error)
+}
+
+var (
+	converters   = map[reflect.Type]converter{}
+	convertersMu sync.RWMutex
+	// converterKinds has the bit 1<<k set if a converter is registered for a
+	// type of kind k. It is read without the lock, so that the values of the
+	// other kinds, e.g. numbers and strings, skip the lookup.
+	converterKinds uint32
+)
+
+// DurationUnit is the unit of the Lua numbers converted to time.Duration
+// values, e.g. time.Second (the default) or time.Nanosecond. Strings are always
+// parsed with time.ParseDuration.
+var DurationUnit = time.Second
+
+func init() {
+	RegisterConverter(reflect.TypeOf(net.IP{}), ipToLua, ipFromLua)
+	RegisterConverter(reflect.TypeOf(url.URL{}), urlToLua, urlFromLua)
+	RegisterConverter(reflect.TypeOf(time.Duration(0)), durationToLua, durationFromLua)
+}
+
+// RegisterConverter sets custom conversions for the values of type 't', e.g.
+// for types that are better represented as strings in Lua than as proxies or
+// tables.
+//
+// 'to' pushes the Lua value for 'v' on the stack. It is used by GoToLua and
+// GoToLuaProxy, including for the elements of containers and for the results of
+// Go functions.
+//
+// 'from' returns the Go value of type 't' for the Lua value at 'idx'. It is
+// used by LuaToGo, including for the arguments of Go functions, but not for
+// 'nil', which converts to the zero value, nor for proxies, which are unwrapped
+// as usual.
+//
+// Either function can be nil to keep the default conversion. Pointers to 't'
+// are followed as for the other types.
+//
+// The following types have built-in converters:
+//
+//	net.IP: to and from its string form.
+//	url.URL: to and from its string form.
+//	time.Duration: to its string form, e.g. "1m30s", and from a string parsed
+//	by time.ParseDuration, e.g. "500ms", or a number of DurationUnit.
+//
+// RegisterConverter can be called concurrently with conversions, which then
+// use either the previous or the new registration.
+func RegisterConverter(t reflect.Type, to func(L *lua.State, v reflect.Value), from func(L *lua.State, idx int) (reflect.Value, error)) {
+	convertersMu.Lock()
+	if to == nil && from == nil {
+		delete(converters, t)
+	} else {
+		converters[t] = converter{to: to, from: from}
+	}
+	var kinds uint32
+	for t := range converters {
+		kinds |= 1 << uint(t.Kind())
+	}
+	atomic.StoreUint32(&converterKinds, kinds)
+	convertersMu.Unlock()
+}
+
+// converterOf returns the converter registered for 't'.
+func converterOf(t reflect.Type) (converter, bool) {
+	if atomic.LoadUint32(&converterKinds)&(1<<uint(t.Kind())) == 0 {
+		return converter{}, false
+	}
+	convertersMu.RLock()
+	c, ok := converters[t]
+	convertersMu.RUnlock()
+	return c, ok
+}
+
+// pushConverted pushes 'v' with the converter of its type, if any. It returns
+// false and pushes nothing otherwise.
+func pushConverted(L *lua.State, v reflect.Value) bool {
+	c, ok := converterOf(v.Type())
+	if !ok || c.to == nil || !v.CanInterface() {
+		return false
+	}
+	c.to(L, v)
+	return true
+}
+
+// convertFromLua sets 'v' with the converter of its type, if any. It returns
+// false if there is no such converter.
+func convertFromLua(L *lua.State, idx int, v reflect.Value) (bool, error) {
+	if L.IsNil(idx) || isValueProxy(L, idx) {
+		return false, nil
+	}
+	c, ok := converterOf(v.Type())
+	if !ok || c.from == nil {
+		return false, nil
+	}
+	val, err := c.from(L, idx)
+	if err != nil {
+		return true, err
+	}
+	v.Set(val.Convert(v.Type()))
+	return true, nil
+}
+
+// checkString returns the Lua string at 'idx'. Numbers are not accepted.
+func checkString(L *lua.State, idx int, t reflect.Type) (string, error) {
+	if L.Type(idx) != lua.LUA_TSTRING {
+		return "", ConvError{From: luaDesc(L, idx), To: t}
+	}
+	return L.ToString(idx), nil
+}
+
+func ipToLua(L *lua.State, v reflect.Value) {
+	L.PushString(v.Interface().(net.IP).String())
+}
+
+func ipFromLua(L *lua.State, idx int) (reflect.Value, error) {
+	t := reflect.TypeOf(net.IP{})
+	s, err := checkString(L, idx, t)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return reflect.Value{}, ConvError{From: luaDesc(L, idx), To: t}
+	}
+	return reflect.ValueOf(ip), nil
+}
+
+func urlToLua(L *lua.State, v reflect.Value) {
+	u := v.Interface().(url.URL)
+	L.PushString(u.String())
+}
+
+func urlFromLua(L *lua.State, idx int) (reflect.Value, error) {
+	s, err := checkString(L, idx, reflect.TypeOf(url.URL{}))
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(*u), nil
+}
+
+func durationToLua(L *lua.State, v reflect.Value) {
+	L.PushString(time.Duration(v.Int()).String())
+}
+
+func durationFromLua(L *lua.State, idx int) (reflect.Value, error) {
+	t := reflect.TypeOf(time.Duration(0))
+	if L.Type(idx) == lua.LUA_TNUMBER {
+		return reflect.ValueOf(time.Duration(L.ToNumber(idx) * float64(DurationUnit))), nil
+	}
+	s, err := checkString(L, idx, t)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return reflect.Value{}, ConvError{From: luaDesc(L, idx), To: t}
+	}
+	return reflect.ValueOf(d), nil
+}
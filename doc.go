@@ -9,8 +9,15 @@ Conversely, most Lua values can be converted to Go values.
 
 Composite types are processed recursively.
 
-Methods can be called on user-defined types. These methods will be callable
-using _dot-notation_ rather than colon notation.
+Methods can be called on user-defined types. The receiver is bound when the
+method is looked up, so both dot and colon notation work: 'p.GetName()' and
+'p:GetName()' are equivalent.
+
+Values implementing io.Reader or io.Writer also have a 'read(n)' method,
+returning a string of up to n bytes or nil at the end of the input, and a
+'write(s)' method, returning the number of bytes written. Both return the error
+message as a second value on failure. Actual methods of the same name take
+precedence.
 
 Arrays, slices, maps and structs can be copied as tables, or alternatively
 passed over as Lua proxy objects which can be naturally indexed.
@@ -18,13 +25,27 @@ passed over as Lua proxy objects which can be naturally indexed.
 In the case of structs and string maps, fields have priority over methods. Use
 'luar.method(<value>, <method>)(<params>...)' to call shadowed methods.
 
-Unexported struct fields are ignored. The "lua" tag is used to match fields in
-struct conversion.
+Unexported struct fields are ignored, unless ReadUnexportedFields is set, in
+which case struct proxies give read-only access to them. The "lua" tag sets the Lua name of a
+field, both in struct conversion and in struct proxies. The "-" tag hides the
+field from Lua. Tags have priority over field names.
+
+A slice field tagged `lua:",rest"` holds the sequence part of tables, i.e. the
+elements 1 to #t, in struct conversions, e.g. {"a", "b", mode="fast"}. These
+integer keys always go to the rest field, even if another field is named after
+them. Other keys map to fields as usual.
+
+The fields of embedded structs are promoted as in Go. Accessing a field promoted
+through a nil embedded pointer raises an error.
 
 You may pass a Lua table to an imported Go function; if the table is
 'array-like' then it is converted to a Go slice; if it is 'map-like' then it
 is converted to a Go map.
 
+A Go function whose first parameter is a *lua.State receives the current state
+without consuming a Lua argument. If it returns a single int, it is the number
+of results the function pushed on the stack, as for a lua.LuaGoFunction.
+
 Pointer values encode as the value pointed to when unproxified.
 
 Usual operators (arithmetic, string concatenation, pairs/ipairs, etc.) work on
@@ -35,8 +56,24 @@ rules are as follows:
 
 - If one type is a Lua number, use the other, user-defined type.
 
-- If the types are different and not Lua numbers, convert to a complex proxy, a
-Lua number, or a Lua string according to the result kind.
+- Arithmetic and comparisons on two distinct user-defined types, e.g. two named
+int types, raise an error.
+
+- Otherwise, if the types are different and not Lua numbers, convert to a
+complex proxy, a Lua number, or a Lua string according to the result kind.
+
+With Lua 5.3 or later, proxies of integer types also support the bitwise
+operators. Both operands must have the same type, or one of them must be a Lua
+number. The result has the type of the proxy.
+
+Proxies of the same type are equal if their Go values are equal. Pointers to
+structs compare the structs they point to. Slices, maps and other
+non-comparable values are only equal to themselves.
+
+'tostring' and string concatenation call the String method of proxies
+implementing fmt.Stringer. Structs and pointers to structs are printed as their
+type and address, e.g. "main.person@0xc000010030", so that their fields are not
+dumped. Other proxies are printed as with fmt's "%v".
 
 
 Channels
@@ -47,7 +84,11 @@ Channel proxies can be manipulated with the following methods:
 
 - recv() value: Fetch and return a value from the channel.
 
-- send(x value): Send a value in the channel.
+- receive() (value, ok boolean): Fetch a value from the channel. 'ok' is false
+and 'value' is nil if the channel is closed.
+
+- send(x value): Send a value in the channel. Sending on a closed channel
+raises an error.
 
 
 Complex numbers
@@ -61,6 +102,11 @@ Complex proxies can be manipulated with the following attributes:
 
 Slices
 
+Slices and arrays are indexed from 1 in Lua, as sequences: the Go element at
+index i is at index i+1 in Lua, be it through a proxy ('s[1]', 'ipairs'), in the
+tables made by GoToLua and 'luar.unproxify', or in the tables converted by
+LuaToGo and 'luar.table2slice'. Fractional indices raise an error.
+
 Slice proxies can be manipulated with the following methods/attributes:
 
 - append(x ...value) sliceProxy: Append the elements and return the new
@@ -71,6 +117,14 @@ slice. The elements must be convertible to the slice element type.
 - slice(i, j integer) sliceProxy: Return the sub-slice that ranges from 'i' to 'j'
 excluded, starting from 1.
 
+- sub(i [, j] integer) sliceProxy: Return the sub-slice that ranges from 'i' to
+'j' included, starting from 1, like 'string.sub'. 'j' defaults to the length of
+the slice. It can also be called as 's:sub(i, j)'.
+
+The sub-slices share their elements with the slice.
+
+Use 'luar.append(s, x ...)' to append to the slice proxy in place.
+
 
 Strings
 
@@ -0,0 +1,218 @@
+package luar
+
+import (
+	"fmt"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+const (
+	// globalsKey is the registry field holding the table where the globals are
+	// stored while a global hook is set.
+	globalsKey = "luar.globals"
+	// globalsMetaKey is the registry field holding the metatable the globals
+	// table had before the hooks were set.
+	globalsMetaKey = "luar.globalsmeta"
+)
+
+// SetGlobalHook makes 'read' and 'write' observe every read and write of a
+// global variable, from scripts as well as from Go, e.g. to enforce access
+// control lists in a plugin sandbox.
+//
+// 'read' is called with the name of the global. If it returns true, the global
+// reads as the returned value, converted with GoToLuaProxy. If it returns
+// false, the global is read as usual.
+//
+// 'write' is called with the name of the global and the new value converted
+// with LuaToGo to an interface{}. Lua functions are passed as LuaObject values,
+// closed once 'write' returns. If it returns true, the write is considered done
+// and the global is left unchanged. If it returns false, the global is written
+// as usual.
+//
+// Errors returned by either function are raised as Lua errors, which vetoes the
+// access. So are the values that cannot be converted for 'write'.
+//
+// Either function can be nil. Keys that are not strings are not hooked. Calling
+// SetGlobalHook again replaces the hooks; calling it with two nil functions
+// removes them.
+//
+// To see every access, the globals are moved to a hidden table while hooks are
+// set, and the metatable of the globals table is replaced. 'pairs' on the
+// globals table still lists them when luar's 'pairs' is installed, see Init,
+// but 'rawget' and 'next' do not. The '__index' and '__newindex' of the previous
+// metatable, e.g. from a strict mode script, still apply to missing globals:
+// they are called with the hidden table. The previous metatable is restored
+// when the hooks are removed.
+func SetGlobalHook(L *lua.State, read func(name string) (interface{}, bool, error), write func(name string, v interface{}) (bool, error)) {
+	L.GetField(lua.LUA_REGISTRYINDEX, globalsKey)
+	hooked := !L.IsNil(-1)
+	if hooked {
+		// Do not go through the current hooks.
+		L.PushString("_G")
+		L.RawGet(-2)
+		L.Remove(-2)
+	} else {
+		L.Pop(1)
+		L.GetGlobal("_G")
+	}
+	globals := L.GetTop()
+
+	if read == nil && write == nil {
+		if hooked {
+			// Restore the globals.
+			L.PushNil()
+			L.SetMetaTable(globals)
+			L.GetField(lua.LUA_REGISTRYINDEX, globalsKey)
+			moveFields(L, -1, globals)
+			L.Pop(1)
+			L.PushNil()
+			L.SetField(lua.LUA_REGISTRYINDEX, globalsKey)
+			L.GetField(lua.LUA_REGISTRYINDEX, globalsMetaKey)
+			L.SetMetaTable(globals)
+			L.PushNil()
+			L.SetField(lua.LUA_REGISTRYINDEX, globalsMetaKey)
+		}
+		L.Pop(1)
+		return
+	}
+
+	if !hooked {
+		if !L.GetMetaTable(globals) {
+			L.PushNil()
+		}
+		L.SetField(lua.LUA_REGISTRYINDEX, globalsMetaKey)
+		L.NewTable()
+		moveFields(L, globals, -1)
+		L.SetField(lua.LUA_REGISTRYINDEX, globalsKey)
+	}
+
+	L.NewTable()
+	L.PushGoFunction(func(L *lua.State) int {
+		if read != nil && L.Type(2) == lua.LUA_TSTRING {
+			v, ok, err := read(L.ToString(2))
+			if err != nil {
+				L.RaiseError(err.Error())
+			}
+			if ok {
+				GoToLuaProxy(L, v)
+				return 1
+			}
+		}
+		L.GetField(lua.LUA_REGISTRYINDEX, globalsKey)
+		L.PushValue(2)
+		L.RawGet(-2)
+		if L.IsNil(-1) && pushGlobalsHandler(L, "__index") {
+			switch {
+			case L.IsFunction(-1):
+				L.PushValue(-3)
+				L.PushValue(2)
+				if err := L.Call(2, 1); err != nil {
+					L.RaiseError(err.Error())
+				}
+			default:
+				L.PushValue(2)
+				L.GetTable(-2)
+			}
+		}
+		return 1
+	})
+	L.SetField(-2, "__index")
+	L.PushGoFunction(func(L *lua.State) int {
+		if write != nil && L.Type(2) == lua.LUA_TSTRING {
+			var v interface{}
+			if err := LuaToGo(L, 3, &v); err != nil {
+				L.RaiseError(fmt.Sprintf("cannot convert global `%s`: %v", L.ToString(2), err))
+			}
+			if lo, ok := v.(*LuaObject); ok {
+				// Functions are only valid during the call.
+				defer lo.Close()
+			}
+			ok, err := write(L.ToString(2), v)
+			if err != nil {
+				L.RaiseError(err.Error())
+			}
+			if ok {
+				return 0
+			}
+		}
+		L.GetField(lua.LUA_REGISTRYINDEX, globalsKey)
+		L.PushValue(2)
+		L.RawGet(-2)
+		missing := L.IsNil(-1)
+		L.Pop(1)
+		if missing && pushGlobalsHandler(L, "__newindex") {
+			switch {
+			case L.IsFunction(-1):
+				L.PushValue(-2)
+				L.PushValue(2)
+				L.PushValue(3)
+				if err := L.Call(3, 0); err != nil {
+					L.RaiseError(err.Error())
+				}
+			default:
+				L.PushValue(2)
+				L.PushValue(3)
+				L.SetTable(-3)
+			}
+			return 0
+		}
+		L.PushValue(2)
+		L.PushValue(3)
+		L.RawSet(-3)
+		return 0
+	})
+	L.SetField(-2, "__newindex")
+	L.PushGoFunction(func(L *lua.State) int {
+		L.GetField(lua.LUA_REGISTRYINDEX, globalsKey)
+		L.PushString("next")
+		L.RawGet(-2)
+		L.Insert(-2)
+		L.PushNil()
+		return 3
+	})
+	L.SetField(-2, "__pairs")
+	L.SetMetaTable(globals)
+	L.Pop(1)
+}
+
+// pushGlobalsHandler pushes the field 'event' of the metatable the globals
+// table had before the hooks were set. It returns false and pushes nothing if
+// there is no such handler.
+func pushGlobalsHandler(L *lua.State, event string) bool {
+	L.GetField(lua.LUA_REGISTRYINDEX, globalsMetaKey)
+	if L.IsNil(-1) {
+		L.Pop(1)
+		return false
+	}
+	L.GetField(-1, event)
+	L.Remove(-2)
+	if L.IsNil(-1) {
+		L.Pop(1)
+		return false
+	}
+	return true
+}
+
+// moveFields moves all the fields of the table at 'from' to the table at 'to'.
+func moveFields(L *lua.State, from, to int) {
+	if from < 0 {
+		from = L.GetTop() + from + 1
+	}
+	if to < 0 {
+		to = L.GetTop() + to + 1
+	}
+	L.PushNil()
+	for L.Next(from) != 0 {
+		L.PushValue(-2)
+		L.Insert(-2)
+		L.RawSet(to)
+	}
+	// Clear 'from' once the traversal is over.
+	L.PushNil()
+	for L.Next(to) != 0 {
+		L.Pop(1)
+		L.PushValue(-1)
+		L.PushNil()
+		L.RawSet(from)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !lua53 && !lua54
+// +build !lua53,!lua54
+
+package luar
+
+// luaHasInteger reports whether the Lua runtime has a native integer subtype.
+// Lua 5.1 and LuaJIT only have floating-point numbers.
+const luaHasInteger = false
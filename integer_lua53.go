@@ -0,0 +1,7 @@
+//go:build lua53 || lua54
+// +build lua53 lua54
+
+package luar
+
+// luaHasInteger reports whether the Lua runtime has a native integer subtype.
+const luaHasInteger = true
@@ -0,0 +1,65 @@
+//go:build lua53 || lua54
+// +build lua53 lua54
+
+package luar
+
+import "testing"
+
+type flags uint32
+
+func TestBitwise(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{
+		"a":     flags(0x5),
+		"b":     flags(0x3),
+		"flags": func(n uint32) flags { return flags(n) },
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`a & b`, `flags(0x1)`},
+		{`a | b`, `flags(0x7)`},
+		{`a ~ b`, `flags(0x6)`},
+		{`~a`, `flags(0xfffffffa)`},
+		{`a << 2`, `flags(0x14)`},
+		{`a >> 1`, `flags(0x2)`},
+		{`a & 4`, `flags(0x4)`},
+		{`b << 31`, `flags(0x80000000)`},
+		{`type(a & b)`, `'table<luar.flags>'`},
+	})
+
+	for _, code := range []string{
+		`return a & luar.slice(1)`,
+		`return a & 1.5`,
+	} {
+		if err := L.DoString(code); err == nil {
+			t.Errorf("missing error from %q", code)
+		}
+		L.SetTop(0)
+	}
+}
+
+func TestInteger(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	// Beyond the float64 precision.
+	want := int64(9007199254740993)
+	GoToLua(L, want)
+	var got int64
+	err := LuaToGo(L, -1, &got)
+	L.Pop(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	runGoTest(t, L, []goTestData{
+		{`9007199254740993`, int64(9007199254740993), ""},
+		{`3.7`, int64(3), ""},
+		{`3.0`, 3, ""},
+	})
+}
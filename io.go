@@ -0,0 +1,73 @@
+package luar
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+)
+
+var (
+	treader = typeof((*io.Reader)(nil))
+	twriter = typeof((*io.Writer)(nil))
+)
+
+// ioMethod returns the 'read' or 'write' convenience method of the value 'v' if
+// it implements io.Reader or io.Writer respectively:
+//
+//	read(n): reads up to 'n' bytes and returns them as a string, or nil at the
+//	end of the input. On other errors, it also returns the error message.
+//	write(s): writes the string 's' and returns the number of bytes written. On
+//	error, it also returns the error message.
+//
+// This hides the byte slices that the Read and Write methods require.
+func ioMethod(name string, v reflect.Value) (reflect.Value, bool) {
+	switch name {
+	case "read":
+		if r, ok := interfaceOf(v, treader).(io.Reader); ok {
+			return reflect.ValueOf(func(n int) (interface{}, interface{}) {
+				if n <= 0 {
+					return "", nil
+				}
+				// The buffer grows with the data read, so that a large 'n' does not
+				// allocate more than the input holds.
+				var buf bytes.Buffer
+				k, err := buf.ReadFrom(io.LimitReader(r, int64(n)))
+				switch {
+				case err == nil && k == 0:
+					return nil, nil
+				case err == nil:
+					return buf.String(), nil
+				case k > 0:
+					return buf.String(), err.Error()
+				}
+				return nil, err.Error()
+			}), true
+		}
+	case "write":
+		if w, ok := interfaceOf(v, twriter).(io.Writer); ok {
+			return reflect.ValueOf(func(s string) (int, interface{}) {
+				n, err := io.WriteString(w, s)
+				if err != nil {
+					return n, err.Error()
+				}
+				return n, nil
+			}), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// interfaceOf returns 'v', or its address, as an interface{} if it implements
+// 't'. It returns nil otherwise.
+func interfaceOf(v reflect.Value, t reflect.Type) interface{} {
+	if !v.CanInterface() {
+		return nil
+	}
+	if v.Type().Implements(t) {
+		return v.Interface()
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && v.Addr().Type().Implements(t) {
+		return v.Addr().Interface()
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package luar
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// JSONMarshalers makes GoToLua and GoToLuaProxy convert the values
+// implementing json.Marshaler through their JSON encoding: objects become
+// tables, arrays become sequences and so on. This is handy to pass data transfer
+// objects to scripts as plain tables. As in the other converted containers, null
+// members and elements become luar.null, while a top-level null becomes nil.
+//
+// It is off by default since the conversion is lossy: the result has no
+// methods and is not bound to the Go value. If the encoding fails, the value
+// is converted as usual.
+var JSONMarshalers = false
+
+// pushJSON pushes the value of 'v' decoded from its JSON encoding. It returns
+// false and pushes nothing if 'v' is not a json.Marshaler or if the encoding
+// fails.
+func pushJSON(L *lua.State, v reflect.Value, visited visitor) bool {
+	if !v.CanInterface() {
+		return false
+	}
+	m, ok := v.Interface().(json.Marshaler)
+	if !ok {
+		return false
+	}
+	data, err := m.MarshalJSON()
+	if err != nil {
+		return false
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return false
+	}
+	goToLua(L, decoded, false, visited)
+	return true
+}
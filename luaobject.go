@@ -2,7 +2,10 @@ package luar
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/aarzilli/golua/lua"
 )
@@ -20,6 +23,7 @@ var (
 	ErrLuaObjectCallResults   = errors.New("results must be a pointer to pointer/slice/struct")
 	ErrLuaObjectCallable      = errors.New("LuaObject must be callable")
 	ErrLuaObjectIndexable     = errors.New("not indexable")
+	ErrLuaObjectNoResult      = errors.New("no result")
 	ErrLuaObjectUnsharedState = errors.New("LuaObjects must share the same state")
 )
 
@@ -51,6 +55,44 @@ func NewLuaObjectFromValue(L *lua.State, val interface{}) *LuaObject {
 	return NewLuaObject(L, -1)
 }
 
+// LoadString compiles 'code' into a function without running it. The returned
+// LuaObject can be called any number of times; the arguments are available in
+// the chunk as '...'.
+func LoadString(L *lua.State, code string) (*LuaObject, error) {
+	if L.LoadString(code) != 0 {
+		return nil, popError(L)
+	}
+	val := NewLuaObject(L, -1)
+	L.Pop(1)
+	return val, nil
+}
+
+// LoadFile is like LoadString for the content of the file at 'path'.
+func LoadFile(L *lua.State, path string) (*LuaObject, error) {
+	if L.LoadFile(path) != 0 {
+		return nil, popError(L)
+	}
+	val := NewLuaObject(L, -1)
+	L.Pop(1)
+	return val, nil
+}
+
+// DoFile runs the file at 'path'. Unlike L.DoFile, the results are discarded.
+func DoFile(L *lua.State, path string) error {
+	chunk, err := LoadFile(L, path)
+	if err != nil {
+		return err
+	}
+	defer chunk.Close()
+	return chunk.Call(nil)
+}
+
+// popError pops the error message left on the stack by a failed load.
+func popError(L *lua.State) error {
+	defer L.Pop(1)
+	return errors.New(L.ToString(-1))
+}
+
 // Call calls a Lua function, given the desired results and the arguments.
 // 'results' must be a pointer to a pointer/struct/slice.
 //
@@ -64,32 +106,15 @@ func NewLuaObjectFromValue(L *lua.State, val interface{}) *LuaObject {
 // argument, they will be ignored.
 //
 // If 'results' is nil, results will be discarded.
+//
+// If the function raises an error, a *LuaError holding the message and the
+// stack traceback is returned.
 func (lo *LuaObject) Call(results interface{}, args ...interface{}) error {
 	L := lo.l
-	// Push the callable value.
-	lo.Push()
-	if !L.IsFunction(-1) {
-		if !L.GetMetaField(-1, "__call") {
-			L.Pop(1)
-			return ErrLuaObjectCallable
-		}
-		// We leave the __call metamethod on stack.
-		L.Remove(-2)
-	}
-
-	// Push the args.
-	for _, arg := range args {
-		GoToLuaProxy(L, arg)
-	}
 
 	// Special case: discard the results.
 	if results == nil {
-		err := L.Call(len(args), 0)
-		if err != nil {
-			L.Pop(1)
-			return err
-		}
-		return nil
+		return lo.call(0, args...)
 	}
 
 	resptr := reflect.ValueOf(results)
@@ -100,18 +125,17 @@ func (lo *LuaObject) Call(results interface{}, args ...interface{}) error {
 
 	switch res.Kind() {
 	case reflect.Ptr:
-		err := L.Call(len(args), 1)
-		defer L.Pop(1)
+		err := lo.call(1, args...)
 		if err != nil {
 			return err
 		}
+		defer L.Pop(1)
 		return LuaToGo(L, -1, res.Interface())
 
 	case reflect.Slice:
-		residx := L.GetTop() - len(args)
-		err := L.Call(len(args), lua.LUA_MULTRET)
+		residx := L.GetTop() + 1
+		err := lo.call(lua.LUA_MULTRET, args...)
 		if err != nil {
-			L.Pop(1)
 			return err
 		}
 
@@ -142,9 +166,8 @@ func (lo *LuaObject) Call(results interface{}, args ...interface{}) error {
 			}
 		}
 		nresults := len(exportedFields)
-		err := L.Call(len(args), nresults)
+		err := lo.call(nresults, args...)
 		if err != nil {
-			L.Pop(1)
 			return err
 		}
 		defer L.Pop(nresults)
@@ -164,6 +187,223 @@ func (lo *LuaObject) Call(results interface{}, args ...interface{}) error {
 	return nil
 }
 
+// call calls the LuaObject with the arguments and leaves 'nresults' results on
+// the stack. It pushes nothing on error.
+func (lo *LuaObject) call(nresults int, args ...interface{}) error {
+	L := lo.l
+	top := L.GetTop()
+	pushCallHelper(L)
+
+	// Push the callable value.
+	lo.Push()
+	if !L.IsFunction(-1) {
+		if !L.GetMetaField(-1, "__call") {
+			L.SetTop(top)
+			return ErrLuaObjectCallable
+		}
+		// We leave the __call metamethod on stack.
+		L.Remove(-2)
+	}
+
+	// Push the args.
+	for _, arg := range args {
+		GoToLuaProxy(L, arg)
+	}
+
+	err := L.Call(len(args)+1, lua.LUA_MULTRET)
+	if err != nil {
+		L.SetTop(top)
+		return err
+	}
+	if !L.ToBoolean(top + 1) {
+		err := newLuaError(L, top+2)
+		L.SetTop(top)
+		return err
+	}
+	L.Remove(top + 1)
+	if nresults != lua.LUA_MULTRET {
+		L.SetTop(top + nresults)
+	}
+	return nil
+}
+
+const callHelperKey = "luar.call"
+
+// callHelper calls a function with 'debug.traceback' as message handler. It
+// returns the results of xpcall. The arguments are passed through a closure
+// since the xpcall of Lua 5.1 does not forward them. Error values other than
+// strings are left as is, which Lua 5.1 'debug.traceback' does not do.
+const callHelper = `
+local unpack = table.unpack or unpack
+local traceback = debug and debug.traceback
+local function handler(msg)
+	if type(msg) ~= "string" or not traceback then
+		return msg
+	end
+	return traceback(msg, 2)
+end
+return function(f, ...)
+	local args = {n = select("#", ...), ...}
+	return xpcall(function()
+		return f(unpack(args, 1, args.n))
+	end, handler)
+end
+`
+
+// pushCallHelper pushes the function used to call Lua functions from Go.
+func pushCallHelper(L *lua.State) {
+	pushLuaHelper(L, callHelperKey, callHelper)
+}
+
+// pushLuaHelper pushes the value returned by the Lua chunk 'code'. The chunk is
+// run once per state and its result is kept in the registry under 'key'.
+func pushLuaHelper(L *lua.State, key, code string) {
+	L.GetField(lua.LUA_REGISTRYINDEX, key)
+	if !L.IsNil(-1) {
+		return
+	}
+	L.Pop(1)
+	if L.LoadString(code) != 0 {
+		panic(popError(L))
+	}
+	L.MustCall(0, 1)
+	L.PushValue(-1)
+	L.SetField(lua.LUA_REGISTRYINDEX, key)
+}
+
+// LuaError is the error returned when a Lua function called from Go fails.
+type LuaError struct {
+	message   string
+	traceback string
+	err       error
+}
+
+func (e *LuaError) Error() string {
+	return e.message
+}
+
+// Message returns the error message, as returned by Error.
+func (e *LuaError) Message() string {
+	return e.message
+}
+
+// Traceback returns the stack traceback at the point of the error, as produced
+// by 'debug.traceback'. It is empty if the error value was not a string or if
+// the debug library is not loaded.
+func (e *LuaError) Traceback() string {
+	return e.traceback
+}
+
+// Unwrap returns the Go error the script failed with, if the error value was a
+// proxy to a Go error, e.g. one raised under WithErrorValues. It returns nil
+// otherwise.
+func (e *LuaError) Unwrap() error {
+	return e.err
+}
+
+// newLuaError returns the error for the error value at 'idx' as left by the
+// message handler of pushCallHelper.
+func newLuaError(L *lua.State, idx int) *LuaError {
+	if err, ok := proxyError(L, idx); ok {
+		return &LuaError{message: err.Error(), err: err}
+	}
+	if !L.IsString(idx) {
+		return &LuaError{message: fmt.Sprintf("(error object is a %s value)", L.LTypename(idx))}
+	}
+	msg := L.ToString(idx)
+	if i := strings.Index(msg, "\nstack traceback:"); i >= 0 {
+		return &LuaError{message: msg[:i], traceback: msg[i+1:]}
+	}
+	return &LuaError{message: msg}
+}
+
+// Call1 calls a Lua function and stores its first result in 'out'. 'out' must
+// be a pointer as in LuaToGo.
+//
+// It returns ErrLuaObjectNoResult if the function returns nothing.
+func (lo *LuaObject) Call1(out interface{}, args ...interface{}) error {
+	L := lo.l
+	top := L.GetTop()
+	err := lo.call(lua.LUA_MULTRET, args...)
+	if err != nil {
+		return err
+	}
+	defer L.SetTop(top)
+	if L.GetTop() == top {
+		return ErrLuaObjectNoResult
+	}
+	return LuaToGo(L, top+1, out)
+}
+
+// CallBool is like Call1 for a boolean result.
+func (lo *LuaObject) CallBool(args ...interface{}) (bool, error) {
+	var res bool
+	err := lo.Call1(&res, args...)
+	return res, err
+}
+
+// CallInt is like Call1 for an integer result.
+func (lo *LuaObject) CallInt(args ...interface{}) (int, error) {
+	var res int
+	err := lo.Call1(&res, args...)
+	return res, err
+}
+
+// CallString is like Call1 for a string result.
+func (lo *LuaObject) CallString(args ...interface{}) (string, error) {
+	var res string
+	err := lo.Call1(&res, args...)
+	return res, err
+}
+
+// CallInto calls 'fn' with 'args' and stores its results in 'outs', which must
+// be pointers as in LuaToGo. The i-th result is converted to the type pointed to
+// by the i-th out. Missing results are converted from nil; extra results are
+// ignored.
+func CallInto(fn *LuaObject, args []interface{}, outs ...interface{}) error {
+	L := fn.l
+	err := fn.call(len(outs), args...)
+	if err != nil {
+		return err
+	}
+	residx := L.GetTop() - len(outs) + 1
+	defer L.Pop(len(outs))
+	for i, out := range outs {
+		err = LuaToGo(L, residx+i, out)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CallErr calls a Lua function following the Lua convention of returning nil
+// and an error message on failure.
+//
+// If the function returns nil followed by a string, the string is returned as
+// an error and the results are nil. Otherwise all the results are returned, but
+// a trailing nil standing for the absence of error is left out.
+func (lo *LuaObject) CallErr(args ...interface{}) ([]interface{}, error) {
+	var res []interface{}
+	err := lo.Call(&res, args...)
+	if err != nil {
+		return nil, err
+	}
+	n := len(res)
+	if n < 2 {
+		return res, nil
+	}
+	if res[0] == nil {
+		if msg, ok := res[n-1].(string); ok {
+			return nil, errors.New(msg)
+		}
+	}
+	if res[n-1] == nil {
+		res = res[:n-1]
+	}
+	return res, nil
+}
+
 // Close frees the Lua reference of this object.
 func (lo *LuaObject) Close() {
 	lo.l.Unref(lua.LUA_REGISTRYINDEX, lo.ref)
@@ -192,10 +432,12 @@ func get(L *lua.State, subfields ...interface{}) error {
 			GoToLua(L, field)
 			err := L.Call(2, 1)
 			if err != nil {
-				L.Pop(1)
+				// Pop the error and the last iterable.
+				L.Pop(2)
 				return err
 			}
 		} else {
+			L.Pop(1)
 			return ErrLuaObjectIndexable
 		}
 		// Remove last iterable.
@@ -217,6 +459,16 @@ func (lo *LuaObject) Get(a interface{}, subfields ...interface{}) error {
 	return LuaToGo(lo.l, -1, a)
 }
 
+// GetPath is like Get with the subfields given as a path, e.g.
+// 'server.ports[1]' or 'mime["text/html"]'. See Get.
+func (lo *LuaObject) GetPath(a interface{}, path string) error {
+	subfields, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	return lo.Get(a, subfields...)
+}
+
 // GetObject returns the LuaObject indexed at the sequence of 'subfields'.
 func (lo *LuaObject) GetObject(subfields ...interface{}) (*LuaObject, error) {
 	lo.Push()
@@ -269,6 +521,111 @@ func (lo *LuaObject) Set(a interface{}, subfields ...interface{}) error {
 	return nil
 }
 
+// SetPath is like Set with the subfields given as a path. See GetPath.
+//
+// If 'create' is true, missing intermediate values are created as empty
+// tables. Otherwise an error is returned.
+func (lo *LuaObject) SetPath(a interface{}, path string, create bool) error {
+	subfields, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	if create {
+		err = lo.createPath(subfields[:len(subfields)-1]...)
+		if err != nil {
+			return err
+		}
+	}
+	return lo.Set(a, subfields...)
+}
+
+// createPath creates empty tables for the missing values along the sequence of
+// 'subfields'.
+func (lo *LuaObject) createPath(subfields ...interface{}) error {
+	L := lo.l
+	top := L.GetTop()
+	defer L.SetTop(top)
+
+	lo.Push()
+	for _, field := range subfields {
+		err := get(L, field)
+		if err != nil {
+			return err
+		}
+		if !L.IsNil(-1) {
+			continue
+		}
+		L.Pop(1)
+		if !L.IsTable(-1) {
+			return ErrLuaObjectIndexable
+		}
+		L.NewTable()
+		GoToLua(L, field)
+		L.PushValue(-2)
+		L.SetTable(-4)
+	}
+	return nil
+}
+
+// parsePath splits a path such as 'a.b[1]["c.d"]' into subfields. Bracketed
+// numbers are integer keys, everything else is a string key.
+func parsePath(path string) ([]interface{}, error) {
+	errPath := fmt.Errorf("invalid path %q", path)
+	var subfields []interface{}
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			if len(subfields) == 0 || i+1 == len(path) || path[i+1] == '.' || path[i+1] == '[' {
+				return nil, errPath
+			}
+			i++
+		case '[':
+			var end int
+			if i+1 < len(path) && (path[i+1] == '"' || path[i+1] == '\'') {
+				end = strings.IndexByte(path[i+2:], path[i+1])
+				if end < 0 {
+					return nil, errPath
+				}
+				end += i + 2
+				subfields = append(subfields, path[i+2:end])
+				// Skip the closing quote.
+				end++
+				if end == len(path) || path[end] != ']' {
+					return nil, errPath
+				}
+			} else {
+				end = strings.IndexByte(path[i:], ']')
+				if end < 0 {
+					return nil, errPath
+				}
+				end += i
+				n, err := strconv.Atoi(path[i+1 : end])
+				if err != nil {
+					return nil, errPath
+				}
+				subfields = append(subfields, n)
+			}
+			i = end + 1
+			if i < len(path) && path[i] != '.' && path[i] != '[' {
+				return nil, errPath
+			}
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path)
+			} else {
+				end += i
+			}
+			subfields = append(subfields, path[i:end])
+			i = end
+		}
+	}
+	if len(subfields) == 0 {
+		return nil, errPath
+	}
+	return subfields, nil
+}
+
 // Setv copies values between two tables in the same Lua state.
 // It overwrites existing values.
 func (lo *LuaObject) Setv(src *LuaObject, keys ...string) error {
@@ -343,6 +700,30 @@ func (ti *LuaTableIter) Error() error {
 	return ti.err
 }
 
+// Each calls 'f' on every key/value pair of the indexable value, respecting
+// the __pairs metamethod, until 'f' returns false. Keys and values are
+// converted with LuaToGo to interface{} values.
+func (lo *LuaObject) Each(f func(key, value interface{}) bool) error {
+	iter, err := lo.Iter()
+	if err != nil {
+		return err
+	}
+	for {
+		// Fresh variables: LuaToGo merges tables into existing maps.
+		var key, value interface{}
+		if !iter.Next(&key, &value) {
+			break
+		}
+		if !f(key, value) {
+			L := lo.l
+			L.Unref(lua.LUA_REGISTRYINDEX, iter.keyRef)
+			L.Unref(lua.LUA_REGISTRYINDEX, iter.iterRef)
+			return nil
+		}
+	}
+	return iter.Error()
+}
+
 // Iter creates a Lua iterator.
 func (lo *LuaObject) Iter() (*LuaTableIter, error) {
 	L := lo.l
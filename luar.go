@@ -5,7 +5,15 @@ package luar
 import (
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aarzilli/golua/lua"
 )
@@ -26,6 +34,80 @@ func (l ConvError) Error() string {
 	return fmt.Sprintf("cannot convert %v to %v", l.From, l.To)
 }
 
+// StrictStructs makes LuaToGo report the table keys that match no struct field.
+// The conversion of the other fields goes on and an error wrapping ErrTableConv
+// and naming the keys is returned. By default, unknown keys are ignored.
+var StrictStructs = false
+
+// FoldFieldNames makes LuaToGo match the table keys to struct fields
+// case-insensitively when no field has the exact name, as encoding/json does,
+// e.g. {name = "Alice"} sets the Name field. Among several such fields, the one
+// declared first wins. Struct proxies always use the exact names.
+var FoldFieldNames = false
+
+// BytesAsString makes GoToLua and GoToLuaProxy push []byte values as Lua
+// strings. The bytes are copied. Set it to false to copy []byte values as tables
+// or pass them as proxies, e.g. when Lua code modifies them in place.
+var BytesAsString = true
+
+// CheckRanges makes LuaToGo return a ConvError when a Lua number is out of the
+// range of the destination integer type, instead of letting it wrap around.
+// Fractional parts are still truncated.
+var CheckRanges = true
+
+// ZeroFillArrays makes LuaToGo accept Lua sequences shorter than the
+// destination Go array, the remaining elements being set to their zero value.
+// When it is false, ErrTableConv is returned instead. Longer sequences always
+// return ErrTableConv, the extra elements being dropped.
+var ZeroFillArrays = true
+
+// StringMapKeys makes GoToLua convert the keys of Go maps that are not
+// booleans, numbers or strings of predeclared types to strings, using their
+// String method if they have one, or fmt.Sprint otherwise. By default such keys
+// are pushed as proxies, which makes the table hard to index from Lua.
+//
+// Distinct keys can have the same string representation, in which case only
+// one of the entries makes it to the table.
+var StringMapKeys = false
+
+// ReadUnexportedFields makes struct proxies expose unexported fields for
+// reading, which can be handy for debugging. The fields are copied to Lua and
+// cannot be set. It is off by default since it bypasses Go's visibility rules.
+var ReadUnexportedFields = false
+
+// StrictRegister makes RegisterAll return an error when several maps define the
+// same name with different values. By default, the value of the last map wins.
+var StrictRegister = false
+
+// PreferIntForWholeNumbers makes LuaToGo convert the Lua numbers without
+// fractional part to int64 instead of float64 when the Go value is an
+// interface, e.g. in the elements of a []interface{} or map[string]interface{}.
+// The other numbers are still converted to float64, as are the numbers out of
+// the range of int64.
+var PreferIntForWholeNumbers = false
+
+// LenientStrings makes LuaToGo accept Lua numbers and booleans for string
+// destinations, converting them with fmt.Sprint, e.g. for configuration fields
+// that scripts may set to 8080 rather than "8080". Whole numbers are formatted
+// without fractional part. By default a ConvError is returned.
+var LenientStrings = false
+
+// TableChannels makes LuaToGo accept Lua sequences for channel destinations,
+// e.g. for the parameters of Go functions consuming a stream of values. The
+// elements are sent to a new channel which is then closed.
+//
+// The Lua state cannot be used from another goroutine, so the elements are
+// converted up front, in the calling goroutine, and the channel is buffered to
+// hold them all: no goroutine is started, the receiver never blocks and nothing
+// leaks if it stops reading early. Send-only channels are not supported.
+var TableChannels = false
+
+// SpreadSliceReturn makes Go functions returning a single []interface{} return
+// its elements as multiple Lua values, as with 'table.unpack', instead of a
+// slice proxy. This suits functions whose number of results is only known at
+// run time. Slices of other types are not affected.
+var SpreadSliceReturn = false
+
 // Lua 5.1 'lua_tostring' function only supports string and numbers. Extend it for internal purposes.
 // From the Lua 5.3 source code.
 func luaToString(L *lua.State, idx int) string {
@@ -66,16 +148,63 @@ var (
 )
 
 var (
-	tslice = typeof((*[]interface{})(nil))
-	tmap   = typeof((*map[string]interface{})(nil))
-	nullv  = reflect.ValueOf(Null)
+	tslice    = typeof((*[]interface{})(nil))
+	tmap      = typeof((*map[string]interface{})(nil))
+	terror    = typeof((*error)(nil))
+	tstringer = typeof((*fmt.Stringer)(nil))
+	tbytes    = typeof((*[]byte)(nil))
+	tint      = typeof((*int)(nil))
+	tstate    = typeof((**lua.State)(nil))
+	nullv     = reflect.ValueOf(Null)
 )
 
+// predeclaredTypes maps the names of Go predeclared types to their type. It is
+// used to resolve the type hints passed from Lua.
+var predeclaredTypes = map[string]reflect.Type{
+	"bool":        typeof((*bool)(nil)),
+	"int":         typeof((*int)(nil)),
+	"int8":        typeof((*int8)(nil)),
+	"int16":       typeof((*int16)(nil)),
+	"int32":       typeof((*int32)(nil)),
+	"int64":       typeof((*int64)(nil)),
+	"uint":        typeof((*uint)(nil)),
+	"uint8":       typeof((*uint8)(nil)),
+	"uint16":      typeof((*uint16)(nil)),
+	"uint32":      typeof((*uint32)(nil)),
+	"uint64":      typeof((*uint64)(nil)),
+	"uintptr":     typeof((*uintptr)(nil)),
+	"float32":     typeof((*float32)(nil)),
+	"float64":     typeof((*float64)(nil)),
+	"complex64":   typeof((*complex64)(nil)),
+	"complex128":  typeof((*complex128)(nil)),
+	"string":      typeof((*string)(nil)),
+	"interface{}": typeof((*interface{})(nil)),
+}
+
+// maxExactFloat is the largest integer such that all the integers of smaller
+// magnitude can be represented exactly by a float64.
+const maxExactFloat = 1 << 53
+
 // visitor holds the index to the table in LUA_REGISTRYINDEX with all the tables
 // we ran across during a GoToLua conversion.
 type visitor struct {
 	L     *lua.State
 	index int
+	// keys maps the visited values to their position in the table.
+	keys map[visitKey]int
+	// fresh disables the reuse of the proxies to struct pointers, see
+	// pushCachedProxy.
+	fresh bool
+}
+
+// visitKey identifies a visited value. Slices sharing the same backing array
+// but with different lengths are distinct values, so the length is part of the
+// key. So is the type, since a struct and its first field share the same
+// address.
+type visitKey struct {
+	ptr uintptr
+	len int
+	t   reflect.Type
 }
 
 func newVisitor(L *lua.State) visitor {
@@ -83,6 +212,7 @@ func newVisitor(L *lua.State) visitor {
 	v.L = L
 	v.L.NewTable()
 	v.index = v.L.Ref(lua.LUA_REGISTRYINDEX)
+	v.keys = map[visitKey]int{}
 	return v
 }
 
@@ -90,35 +220,47 @@ func (v *visitor) close() {
 	v.L.Unref(lua.LUA_REGISTRYINDEX, v.index)
 }
 
+// keyOf returns the key under which 'val' is recorded as visited.
+func keyOf(val reflect.Value) visitKey {
+	k := visitKey{ptr: val.Pointer(), t: val.Type()}
+	if val.Kind() == reflect.Slice {
+		k.len = val.Len()
+	}
+	return k
+}
+
 // Mark value on top of the stack as visited using the registry index.
 func (v *visitor) mark(val reflect.Value) {
-	ptr := val.Pointer()
-	if ptr == 0 {
+	if val.Pointer() == 0 {
 		// We do not mark uninitialized 'val' as this is meaningless and this would
 		// bind all uninitialized values to the same mark.
 		return
 	}
 
+	k := keyOf(val)
+	n, ok := v.keys[k]
+	if !ok {
+		n = len(v.keys) + 1
+		v.keys[k] = n
+	}
 	v.L.RawGeti(lua.LUA_REGISTRYINDEX, v.index)
 	// Copy value on top.
 	v.L.PushValue(-2)
 	// Set value to table.
-	// TODO: Handle overflow.
-	v.L.RawSeti(-2, int(ptr))
+	v.L.RawSeti(-2, n)
 	v.L.Pop(1)
 }
 
 // Push visited value on top of the stack.
 // If the value was not visited, return false and push nothing.
 func (v *visitor) push(val reflect.Value) bool {
-	ptr := val.Pointer()
-	v.L.RawGeti(lua.LUA_REGISTRYINDEX, v.index)
-	v.L.RawGeti(-1, int(ptr))
-	if v.L.IsNil(-1) {
+	n, ok := v.keys[keyOf(val)]
+	if !ok {
 		// Not visited.
-		v.L.Pop(2)
 		return false
 	}
+	v.L.RawGeti(lua.LUA_REGISTRYINDEX, v.index)
+	v.L.RawGeti(-1, n)
 	v.L.Replace(-2)
 	return true
 }
@@ -127,14 +269,35 @@ func (v *visitor) push(val reflect.Value) bool {
 //
 // It populates the 'luar' table with some helper functions/values:
 //
+//   append: ProxyAppend
+//   bind: Bind
+//   clone: ProxyClone
+//   deepequal: DeepEqual
+//   errorvalue: ErrorValue
+//   fields: ProxyFields
+//   keys: ProxyKeys
+//   mapget: ProxyMapGet
 //   method: ProxyMethod
+//   methods: ProxyMethods
+//   new: ProxyNew
+//   onfinalize: ProxyOnFinalize
+//   pairsSorted: ProxyPairsSorted
+//   raw: Raw
+//   select: Select
+//   setmeta: ProxySetMeta
+//   tostring: ToString
+//   typeof: ProxyTypeOf
+//   values: ProxyValues
 //   unproxify: Unproxify
 //
 //   chan: MakeChan
 //   complex: MakeComplex
 //   map: MakeMap
 //   slice: MakeSlice
+//   table2map: TableToMap
+//   table2slice: TableToSlice
 //
+//   isnull: IsNull
 //   null: Null
 //
 // It replaces the 'pairs'/'ipairs' functions with ProxyPairs/ProxyIpairs
@@ -144,23 +307,118 @@ func (v *visitor) push(val reflect.Value) bool {
 // It also replaces the 'type' function with ProxyType.
 //
 // It is not required for using the 'GoToLua' and 'LuaToGo' functions.
+//
+// See InitWith to configure the state.
 func Init() *lua.State {
-	var L = lua.NewState()
+	return InitWith()
+}
+
+// Option configures the states made by InitWith.
+type Option func(*initOptions)
+
+type initOptions struct {
+	alloc         lua.Alloc
+	panicf        lua.LuaGoFunction
+	without       []string
+	namespaceOnly bool
+}
+
+// WithAllocator makes the state use 'f' to allocate memory, see
+// lua.NewStateAlloc.
+func WithAllocator(f lua.Alloc) Option {
+	return func(o *initOptions) {
+		o.alloc = f
+	}
+}
+
+// WithPanicHandler sets the function called by Lua on unprotected errors, see
+// lua_atpanic.
+func WithPanicHandler(f lua.LuaGoFunction) Option {
+	return func(o *initOptions) {
+		o.panicf = f
+	}
+}
+
+// WithoutLibs removes the standard libraries 'names', e.g. "io", "os" and
+// "debug", so that untrusted scripts cannot reach them. They are removed from
+// the globals and from 'package.loaded'.
+func WithoutLibs(names ...string) Option {
+	return func(o *initOptions) {
+		o.without = append(o.without, names...)
+	}
+}
+
+// NamespaceOnly only registers the 'luar' table: the 'pairs', 'ipairs' and
+// 'type' functions are left unchanged.
+func NamespaceOnly() Option {
+	return func(o *initOptions) {
+		o.namespaceOnly = true
+	}
+}
+
+// InitWith is like Init but configures the state with 'opts'. For instance, a
+// state for untrusted scripts can be made with
+//
+//	L := luar.InitWith(luar.WithoutLibs("io", "os", "debug", "package"))
+func InitWith(opts ...Option) *lua.State {
+	var o initOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var L *lua.State
+	if o.alloc != nil {
+		L = lua.NewStateAlloc(o.alloc)
+	} else {
+		L = lua.NewState()
+	}
+	if o.panicf != nil {
+		L.AtPanic(o.panicf)
+	}
 	L.OpenLibs()
+	removeLibs(L, o.without)
+
 	Register(L, "luar", Map{
 		// Functions.
 		"unproxify": Unproxify,
 
-		"method": ProxyMethod,
+		"append":    ProxyAppend,
+		"bind":      Bind,
+		"clone":     ProxyClone,
+		"deepequal": DeepEqual,
+		"fields":    ProxyFields,
+		"keys":      ProxyKeys,
+		"mapget":    ProxyMapGet,
+		"method":    ProxyMethod,
+		"methods":   ProxyMethods,
+		"new":       ProxyNew,
+		"values":    ProxyValues,
+
+		"errorvalue":  ErrorValue,
+		"onfinalize":  ProxyOnFinalize,
+		"pairsSorted": ProxyPairsSorted,
+		"raw":         Raw,
+		"select":      Select,
+		"setmeta":     ProxySetMeta,
+		"tostring":    ToString,
+		"typeof":      ProxyTypeOf,
 
 		"chan":    MakeChan,
 		"complex": Complex,
 		"map":     MakeMap,
 		"slice":   MakeSlice,
 
+		"table2map":   TableToMap,
+		"table2slice": TableToSlice,
+
+		"isnull": IsNull,
+
 		// Values.
 		"null": Null,
 	})
+	if o.namespaceOnly {
+		return L
+	}
 	Register(L, "", Map{
 		"pairs": ProxyPairs,
 		"type":  ProxyType,
@@ -170,6 +428,25 @@ func Init() *lua.State {
 	return L
 }
 
+// removeLibs removes the standard libraries 'names' from the globals and from
+// 'package.loaded', so that 'require' cannot return them either.
+func removeLibs(L *lua.State, names []string) {
+	L.GetGlobal("package")
+	if L.IsTable(-1) {
+		L.GetField(-1, "loaded")
+		L.Remove(-2)
+	}
+	for _, name := range names {
+		L.PushNil()
+		L.SetGlobal(name)
+		if L.IsTable(-1) {
+			L.PushNil()
+			L.SetField(-2, name)
+		}
+	}
+	L.Pop(1)
+}
+
 func isNil(v reflect.Value) bool {
 	nullables := [...]bool{
 		reflect.Chan:      true,
@@ -193,7 +470,11 @@ func copyMapToTable(L *lua.State, v reflect.Value, visited visitor) {
 	visited.mark(v)
 	for _, key := range v.MapKeys() {
 		val := v.MapIndex(key)
-		goToLua(L, key, true, visited)
+		if StringMapKeys && !isPrimitive(key) {
+			L.PushString(keyString(key))
+		} else {
+			goToLua(L, key, true, visited)
+		}
 		if isNil(val) {
 			val = nullv
 		}
@@ -202,6 +483,33 @@ func copyMapToTable(L *lua.State, v reflect.Value, visited visitor) {
 	}
 }
 
+// isPrimitive reports whether 'v' holds a boolean, a number or a string of a
+// predeclared type, possibly behind an interface.
+func isPrimitive(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return !isNewType(v.Type())
+	}
+	return false
+}
+
+// keyString returns the string representation of a map key. See StringMapKeys.
+func keyString(key reflect.Value) string {
+	if !key.CanInterface() {
+		return fmt.Sprint(key)
+	}
+	if s, ok := key.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprint(key.Interface())
+}
+
 // Also for arrays.
 func copySliceToTable(L *lua.State, v reflect.Value, visited visitor) {
 	vp := v
@@ -236,38 +544,106 @@ func copyStructToTable(L *lua.State, v reflect.Value, visited visitor) {
 		v = v.Elem()
 	}
 
-	n := v.NumField()
-	L.CreateTable(n, 0)
+	fields := structFields(v.Type())
+	rest := restField(v.Type())
+	L.CreateTable(0, len(fields))
 	if vp.Kind() == reflect.Ptr {
 		visited.mark(vp)
 	}
 
-	for i := 0; i < n; i++ {
-		st := v.Type()
-		field := st.Field(i)
-		key := field.Name
-		tag := field.Tag.Get("lua")
-		if tag != "" {
-			key = tag
+	if rest != nil {
+		s := v.FieldByIndex(rest)
+		for i := 0; i < s.Len(); i++ {
+			L.PushInteger(int64(i + 1))
+			val := s.Index(i)
+			if isNil(val) {
+				val = nullv
+			}
+			goToLua(L, val, false, visited)
+			L.SetTable(-3)
+		}
+	}
+
+	for key, index := range fields {
+		if rest != nil && reflect.DeepEqual(index, rest) {
+			// Already in the sequence part.
+			continue
+		}
+		val, ok := fieldByIndex(v, index, false)
+		if !ok {
+			continue
 		}
 		goToLua(L, key, false, visited)
-		val := v.Field(i)
 		goToLua(L, val, false, visited)
 		L.SetTable(-3)
 	}
 }
 
+// callGoFunction calls 'v' and turns Go panics into Lua errors so that scripts
+// can catch them with 'pcall'.
 func callGoFunction(L *lua.State, v reflect.Value, args []reflect.Value) []reflect.Value {
-	defer func() {
-		if x := recover(); x != nil {
-			L.RaiseError(fmt.Sprintf("error %s", x))
-		}
-	}()
+	defer raiseGoPanic(L)
 	results := v.Call(args)
 	return results
 }
 
-func goToLuaFunction(L *lua.State, v reflect.Value) lua.LuaGoFunction {
+// raiseGoPanic turns a Go panic into a Lua error. It must be deferred.
+func raiseGoPanic(L *lua.State) {
+	if x := recover(); x != nil {
+		L.RaiseError(fmt.Sprintf("error %v", x))
+	}
+}
+
+// isTableTarget reports whether Lua tables can be converted to 't' as a single
+// value.
+func isTableTarget(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.Struct, reflect.Interface, reflect.Ptr, reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+// isOptionsType reports whether the values of type 't' can be the trailing
+// "options" parameter of a Go function called from Lua, i.e. a map with string
+// keys or a struct, or a pointer to one.
+func isOptionsType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String
+	case reflect.Struct:
+		return true
+	}
+	return false
+}
+
+// errorMode sets how a Go function called from Lua reports the non-nil error
+// it returns last.
+type errorMode int
+
+const (
+	// The error is returned as a proxy, like any other value.
+	errorsAsProxies errorMode = iota
+	// A Lua error is raised with the error message and the other results are
+	// dropped.
+	errorsRaised
+	// The other results are replaced by nil and the error by its message.
+	errorsAsMessages
+	// The results are replaced by the error marker and a proxy to the error, for
+	// wrapErrorValues to raise it.
+	errorsAsValues
+)
+
+// goToLuaFunction wraps the Go function 'v' into a Lua function. See errorMode
+// for the handling of errors.
+//
+// If the first parameter of 'v' is a *lua.State, it receives the state and
+// consumes no Lua argument. If 'v' then returns a single int, it is the number
+// of results 'v' pushed on the stack, as for a lua.LuaGoFunction.
+func goToLuaFunction(L *lua.State, v reflect.Value, mode errorMode) lua.LuaGoFunction {
 	switch f := v.Interface().(type) {
 	case func(*lua.State) int:
 		return f
@@ -279,39 +655,97 @@ func goToLuaFunction(L *lua.State, v reflect.Value) lua.LuaGoFunction {
 		argsT[i] = t.In(i)
 	}
 
+	offset := 0
+	if len(argsT) > 0 && argsT[0] == tstate {
+		offset = 1
+	}
+	returnsCount := offset == 1 && t.NumOut() == 1 && t.Out(0) == tint
+
 	return func(L *lua.State) int {
+		unrefCollected(L)
+
 		var lastT reflect.Type
 		isVariadic := t.IsVariadic()
 
+		// Do not modify 'argsT': it is shared by all calls.
+		fixedT := argsT
 		if isVariadic {
 			n := len(argsT)
 			lastT = argsT[n-1].Elem()
-			argsT = argsT[:n-1]
+			fixedT = argsT[:n-1]
 		}
+		// Number of fixed Lua arguments.
+		nfixed := len(fixedT) - offset
 
-		args := make([]reflect.Value, len(argsT))
-		for i, t := range argsT {
-			val := reflect.New(t)
-			err := LuaToGo(L, i+1, val.Interface())
-			if err != nil {
-				L.RaiseError(fmt.Sprintf("cannot convert Go function argument #%v: %v", i, err))
+		args := make([]reflect.Value, len(fixedT))
+		if offset == 1 {
+			args[0] = reflect.ValueOf(L)
+		}
+		for i := 1; i <= nfixed; i++ {
+			val := reflect.New(fixedT[i-1+offset])
+			if i == nfixed && i > L.GetTop() && isOptionsType(val.Elem().Type()) {
+				// The options table was omitted.
+				args[i-1+offset] = val.Elem()
+				continue
+			}
+			if err := LuaToGo(L, i, val.Interface()); err != nil {
+				L.RaiseError(fmt.Sprintf("cannot convert Go function argument #%v: %v", i-1, err))
 			}
-			args[i] = val.Elem()
+			args[i-1+offset] = val.Elem()
 		}
 
 		if isVariadic {
 			n := L.GetTop()
-			for i := len(argsT) + 1; i <= n; i++ {
+			if n == nfixed+1 && L.IsTable(n) && !isTableTarget(lastT) {
+				// A single table holds all the variadic arguments.
+				val := reflect.New(argsT[len(fixedT)])
+				if err := LuaToGo(L, n, val.Interface()); err != nil {
+					L.RaiseError(fmt.Sprintf("cannot convert Go function argument #%v: %v", n, err))
+				}
+				for i := 0; i < val.Elem().Len(); i++ {
+					args = append(args, val.Elem().Index(i))
+				}
+				n = 0
+			}
+			for i := nfixed + 1; i <= n; i++ {
 				val := reflect.New(lastT)
-				err := LuaToGo(L, i, val.Interface())
-				if err != nil {
+				if err := LuaToGo(L, i, val.Interface()); err != nil {
 					L.RaiseError(fmt.Sprintf("cannot convert Go function argument #%v: %v", i, err))
 				}
 				args = append(args, val.Elem())
 			}
-			argsT = argsT[:len(argsT)+1]
 		}
 		results := callGoFunction(L, v, args)
+		if returnsCount {
+			return int(results[0].Int())
+		}
+		if mode != errorsAsProxies && len(results) > 0 {
+			last := results[len(results)-1]
+			if last.Type().Implements(terror) && !isNil(last) {
+				msg := last.Interface().(error).Error()
+				if mode == errorsRaised {
+					L.RaiseError(msg)
+				}
+				if mode == errorsAsValues {
+					pushErrorMarker(L)
+					GoToLuaProxy(L, last)
+					if isValueProxy(L, -1) {
+						return 2
+					}
+					// Converters and marshalers may turn it into a plain value.
+					L.Pop(2)
+					L.RaiseError(msg)
+				}
+				for range results[1:] {
+					L.PushNil()
+				}
+				L.PushString(msg)
+				return len(results)
+			}
+		}
+		if SpreadSliceReturn && len(results) == 1 && results[0].Type() == tslice {
+			return pushSpread(L, results[0])
+		}
 		for _, val := range results {
 			GoToLuaProxy(L, val)
 		}
@@ -319,11 +753,49 @@ func goToLuaFunction(L *lua.State, v reflect.Value) lua.LuaGoFunction {
 	}
 }
 
+// pushSpread pushes the elements of the slice 'v' on the stack and returns
+// their number.
+func pushSpread(L *lua.State, v reflect.Value) int {
+	if !L.CheckStack(v.Len()) {
+		L.RaiseError(fmt.Sprintf("too many results to unpack: %v", v.Len()))
+	}
+	for i := 0; i < v.Len(); i++ {
+		GoToLuaProxy(L, v.Index(i))
+	}
+	return v.Len()
+}
+
 // GoToLua pushes a Go value 'val' on the Lua stack.
 //
 // It unboxes interfaces.
 //
 // Pointers are followed recursively. Slices, structs and maps are copied over as tables.
+//
+// Nil pointers, interfaces, slices, maps, channels and functions are pushed as
+// nil.
+//
+// Functions are pushed as Lua functions converting their arguments with LuaToGo
+// and their results with GoToLuaProxy, so a function returned by another one can
+// be called from Lua. A single []interface{} result can be spread over several
+// Lua values: see SpreadSliceReturn.
+//
+// The last non-variadic parameter of a function can take an options table, as
+// in 'f(x, {verbose = true})', if it is a map with string keys or a struct, or a
+// pointer to one: the table is converted with LuaToGo and may be omitted, in
+// which case the parameter is the zero value. Set StrictStructs to reject the
+// unknown options of a struct.
+//
+// time.Time values are converted to tables or numbers: see TimeAsUnix.
+//
+// []byte values are converted to strings: see BytesAsString.
+//
+// json.Marshaler values can be converted through JSON: see JSONMarshalers.
+//
+// encoding.TextMarshaler values can be converted to strings: see
+// TextMarshalers.
+//
+// Types can be proxified instead: see SetConversionPolicy. Custom conversions
+// can be registered: see RegisterConverter.
 func GoToLua(L *lua.State, a interface{}) {
 	visited := newVisitor(L)
 	goToLua(L, a, false, visited)
@@ -365,12 +837,64 @@ func GoToLua(L *lua.State, a interface{}) {
 // of indirections, the arguments will be converted automatically. Since proxies
 // can only wrap around one level of indirection, functions modifying the value
 // of the pointers after one level of indirection will have no effect.
+//
+// Errors are proxified: 'tostring(err)' and 'err:Error()' return the message.
+//
+// The same proxy is pushed for the same pointer to a struct as long as it is
+// alive in the state, so that proxies can be compared with 'rawequal' and used
+// as table keys. Metatables set with 'luar.setmeta' are thus shared as well.
+//
+// The conversion of a type can be forced either way: see SetConversionPolicy.
 func GoToLuaProxy(L *lua.State, a interface{}) {
 	visited := newVisitor(L)
 	goToLua(L, a, true, visited)
 	visited.close()
 }
 
+// GoToLuaReadOnly is like GoToLuaProxy, but the resulting proxy is read-only:
+// assigning to a field, an element or a map entry raises an error, as does
+// 'luar.append'. Reads work as usual. The proxies obtained from it by indexing,
+// iterating or slicing are read-only as well.
+//
+// Methods are not restricted: a method with a pointer receiver can still modify
+// the value.
+func GoToLuaReadOnly(L *lua.State, a interface{}) {
+	visited := newVisitor(L)
+	// Do not make a shared proxy read-only.
+	visited.fresh = true
+	goToLua(L, a, true, visited)
+	visited.close()
+	setReadOnly(L, -1)
+}
+
+// IsProxy reports whether the value at 'idx' is a proxy to a Go value.
+func IsProxy(L *lua.State, idx int) bool {
+	return isValueProxy(L, idx)
+}
+
+// UnwrapProxy returns the Go value wrapped by the proxy at 'idx'. The boolean
+// is false if the value is not a proxy.
+//
+// The proxy only anchors the Go value while it lives in the Lua state. The
+// returned reflect.Value holds its own reference and remains valid after the
+// proxy has been garbage-collected. It shares the data of the proxy: changes to
+// reference types (pointers, slices, maps) are seen on both sides, but
+// operations replacing the proxied value (e.g. 'append' on a non-settable
+// slice) are not reflected in a value unwrapped earlier.
+func UnwrapProxy(L *lua.State, idx int) (reflect.Value, bool) {
+	if !isValueProxy(L, idx) {
+		return reflect.Value{}, false
+	}
+	proxyId := *(*uintptr)(L.ToUserdata(idx))
+	proxymu.RLock()
+	val, ok := proxyMap[proxyId]
+	proxymu.RUnlock()
+	if !ok {
+		return reflect.Value{}, false
+	}
+	return val.v, true
+}
+
 func goToLua(L *lua.State, a interface{}, proxify bool, visited visitor) {
 	var v reflect.Value
 	v, ok := a.(reflect.Value)
@@ -397,17 +921,44 @@ func goToLua(L *lua.State, a interface{}, proxify bool, visited visitor) {
 		v = v.Elem()
 	}
 
-	if !v.IsValid() {
+	// Nil references, e.g. a nil pointer or a nil slice returned by a function,
+	// are pushed as nil so that scripts can compare them to nil. Containers
+	// hold luar.null instead, see copySliceToTable and copyMapToTable.
+	if !v.IsValid() || isNil(v) {
 		L.PushNil()
 		return
 	}
 
+	if pushConverted(L, v) {
+		return
+	}
+
+	if v.Type() == ttime && v.CanInterface() {
+		pushTime(L, v.Interface().(time.Time))
+		return
+	}
+
+	if JSONMarshalers && pushJSON(L, vp, visited) {
+		return
+	}
+
+	if TextMarshalers && pushText(L, vp) {
+		return
+	}
+
+	if v.Type() == tbytes && BytesAsString {
+		L.PushString(string(v.Bytes()))
+		return
+	}
+
 	// As a special case, we always proxify Null, the empty element for slices and maps.
 	if v.CanInterface() && v.Interface() == Null {
-		makeValueProxy(L, v, cInterfaceMeta)
+		pushNull(L)
 		return
 	}
 
+	proxify = applyPolicy(v.Type(), proxify)
+
 	switch v.Kind() {
 	case reflect.Float64, reflect.Float32:
 		if proxify && isNewType(v.Type()) {
@@ -418,12 +969,22 @@ func goToLua(L *lua.State, a interface{}, proxify bool, visited visitor) {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		if proxify && isNewType(v.Type()) {
 			makeValueProxy(L, vp, cNumberMeta)
+		} else if luaHasInteger {
+			L.PushInteger(v.Int())
+		} else if i := v.Int(); i > maxExactFloat || i < -maxExactFloat {
+			// Keep the exact value, a Lua number would round it.
+			makeValueProxy(L, vp, cNumberMeta)
 		} else {
-			L.PushNumber(float64(v.Int()))
+			L.PushNumber(float64(i))
 		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		if proxify && isNewType(v.Type()) {
 			makeValueProxy(L, vp, cNumberMeta)
+		} else if luaHasInteger && v.Uint() <= math.MaxInt64 {
+			L.PushInteger(int64(v.Uint()))
+		} else if luaHasInteger || v.Uint() > maxExactFloat {
+			// Keep the exact value, a Lua number would round it.
+			makeValueProxy(L, vp, cNumberMeta)
 		} else {
 			L.PushNumber(float64(v.Uint()))
 		}
@@ -486,8 +1047,7 @@ func goToLua(L *lua.State, a interface{}, proxify bool, visited visitor) {
 			if vp.CanInterface() {
 				switch v := vp.Interface().(type) {
 				case error:
-					// TODO: Test proxification of errors.
-					L.PushString(v.Error())
+					makeValueProxy(L, vp, cInterfaceMeta)
 					return
 				case *LuaObject:
 					// TODO: Move out of 'proxify' condition? LuaObject is meant to be
@@ -504,6 +1064,13 @@ func goToLua(L *lua.State, a interface{}, proxify bool, visited visitor) {
 				}
 			}
 
+			// The proxies to the same struct pointer are shared so that they compare
+			// with 'rawequal' and can be used as table keys.
+			shared := vp.Kind() == reflect.Ptr && v.CanSet() && !visited.fresh
+			if shared && pushCachedProxy(L, vp) {
+				return
+			}
+
 			// Structs are always user-defined types, so it makes sense to always
 			// proxify them.
 			if !v.CanSet() {
@@ -511,6 +1078,9 @@ func goToLua(L *lua.State, a interface{}, proxify bool, visited visitor) {
 				vp.Elem().Set(v)
 			}
 			makeValueProxy(L, vp, cStructMeta)
+			if shared {
+				cacheProxy(L, vp)
+			}
 		} else {
 			// Use vp instead of v to detect cycles from the very first element, if a pointer.
 			if vp.Kind() == reflect.Ptr && visited.push(vp) {
@@ -521,10 +1091,11 @@ func goToLua(L *lua.State, a interface{}, proxify bool, visited visitor) {
 	case reflect.Chan:
 		makeValueProxy(L, vp, cChannelMeta)
 	case reflect.Func:
-		L.PushGoFunction(goToLuaFunction(L, v))
+		L.PushGoFunction(goToLuaFunction(L, v, errorsAsProxies))
+		recordFuncType(L, v.Type())
 	default:
-		if val, ok := v.Interface().(error); ok {
-			L.PushString(val.Error())
+		if _, ok := v.Interface().(error); ok {
+			makeValueProxy(L, vp, cInterfaceMeta)
 		} else if v.IsNil() {
 			L.PushNil()
 		} else {
@@ -533,6 +1104,31 @@ func goToLua(L *lua.State, a interface{}, proxify bool, visited visitor) {
 	}
 }
 
+// nullKey is the registry field holding the proxy to Null.
+const nullKey = "luar.null"
+
+// pushNull pushes the proxy to Null. It is the same for all the conversions in
+// a state so that it can be compared with 'rawequal' or used as a table key.
+func pushNull(L *lua.State) {
+	L.GetField(lua.LUA_REGISTRYINDEX, nullKey)
+	if !L.IsNil(-1) {
+		return
+	}
+	L.Pop(1)
+	makeValueProxy(L, nullv, cInterfaceMeta)
+	L.PushValue(-1)
+	L.SetField(lua.LUA_REGISTRYINDEX, nullKey)
+}
+
+// isNullProxy reports whether the value at 'idx' is luar.null.
+func isNullProxy(L *lua.State, idx int) bool {
+	if !isValueProxy(L, idx) {
+		return false
+	}
+	v, _ := valueOfProxy(L, idx)
+	return v.Interface() == Null
+}
+
 func luaIsEmpty(L *lua.State, idx int) bool {
 	L.PushNil()
 	if idx < 0 {
@@ -606,6 +1202,8 @@ func copyTableToSlice(L *lua.State, idx int, v reflect.Value, visited map[uintpt
 	// Adjust the length of the array/slice.
 	if n > v.Len() {
 		if t.Kind() == reflect.Array {
+			// The extra elements do not fit.
+			status = ErrTableConv
 			n = v.Len()
 		} else {
 			// Slice
@@ -613,6 +1211,9 @@ func copyTableToSlice(L *lua.State, idx int, v reflect.Value, visited map[uintpt
 		}
 	} else if n < v.Len() {
 		if t.Kind() == reflect.Array {
+			if !ZeroFillArrays {
+				status = ErrTableConv
+			}
 			// Nullify remaining elements.
 			for i := n; i < v.Len(); i++ {
 				v.Index(i).Set(reflect.Zero(t.Elem()))
@@ -648,6 +1249,27 @@ func copyTableToSlice(L *lua.State, idx int, v reflect.Value, visited map[uintpt
 	return
 }
 
+// copyTableToChan sets 'v' to a new channel holding the elements of the Lua
+// sequence at 'idx'. The channel is closed. See TableChannels.
+func copyTableToChan(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect.Value) (status error) {
+	t := v.Type()
+	n := int(L.ObjLen(idx))
+	ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, t.Elem()), n)
+	for i := 1; i <= n; i++ {
+		L.RawGeti(idx, i)
+		val := reflect.New(t.Elem()).Elem()
+		if luaToGo(L, -1, val, visited) != nil {
+			status = ErrTableConv
+		} else {
+			ch.Send(val)
+		}
+		L.Pop(1)
+	}
+	ch.Close()
+	v.Set(ch.Convert(t))
+	return status
+}
+
 func copyTableToStruct(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect.Value) (status error) {
 	t := v.Type()
 
@@ -657,17 +1279,29 @@ func copyTableToStruct(L *lua.State, idx int, v reflect.Value, visited map[uintp
 		visited[ptr] = v.Addr()
 	}
 
-	// Associate Lua keys with Go fields: tags have priority over matching field
-	// name.
-	fields := map[string]string{}
-	for i := 0; i < v.NumField(); i++ {
-		field := t.Field(i)
-		tag := field.Tag.Get("lua")
-		if tag != "" {
-			fields[tag] = field.Name
-			continue
+	var unknown []string
+	defer func() {
+		if unknown != nil {
+			sort.Strings(unknown)
+			status = fmt.Errorf("%w: unknown keys %s", ErrTableConv, strings.Join(unknown, ", "))
+		}
+	}()
+
+	// The sequence part goes to the rest field, if any.
+	nrest := 0
+	rest := restField(t)
+	if rest != nil {
+		f := v.FieldByIndex(rest)
+		nrest = int(L.ObjLen(idx))
+		s := reflect.MakeSlice(f.Type(), nrest, nrest)
+		for i := 1; i <= nrest; i++ {
+			L.RawGeti(idx, i)
+			if luaToGo(L, -1, s.Index(i-1), visited) != nil {
+				status = ErrTableConv
+			}
+			L.Pop(1)
 		}
-		fields[field.Name] = field.Name
+		f.Set(s)
 	}
 
 	L.PushNil()
@@ -675,12 +1309,33 @@ func copyTableToStruct(L *lua.State, idx int, v reflect.Value, visited map[uintp
 		idx--
 	}
 	for L.Next(idx) != 0 {
+		if nrest > 0 && L.Type(-2) == lua.LUA_TNUMBER {
+			if k := L.ToNumber(-2); k >= 1 && k <= float64(nrest) && k == math.Trunc(k) {
+				// Already in the rest field.
+				L.Pop(1)
+				continue
+			}
+		}
 		L.PushValue(-2)
 		// Warning: ToString changes the value on stack.
 		key := L.ToString(-1)
 		L.Pop(1)
-		f := v.FieldByName(fields[key])
-		if f.CanSet() {
+		index, ok := lookupField(t, key)
+		if ok && rest != nil && reflect.DeepEqual(index, rest) {
+			// The rest field only holds the sequence part.
+			ok = false
+		}
+		if !ok {
+			if StrictStructs && key != typeField {
+				unknown = append(unknown, strconv.Quote(key))
+			}
+			L.Pop(1)
+			continue
+		}
+		f, ok := fieldByIndex(v, index, true)
+		if !ok {
+			status = ErrTableConv
+		} else if f.CanSet() {
 			val := reflect.New(f.Type()).Elem()
 			err := luaToGo(L, -1, val, visited)
 			if err != nil {
@@ -705,14 +1360,36 @@ func copyTableToStruct(L *lua.State, idx int, v reflect.Value, visited map[uintp
 // Lua 'nil' is converted to the zero value of the specified Go value.
 //
 // If the Lua value is non-nil, pointers are dereferenced (multiple times if
-// required) and the pointed value is the one that is set. If 'nil', then the Go
-// pointer is set to 'nil'. To set a pointer's value to its zero value, use
-// 'luar.null'.
+// required) and the pointed value is the one that is set. Nil pointers on the
+// way, e.g. in a '**T' value, are allocated. If 'nil', then the Go pointer is
+// set to 'nil'. So is it with 'luar.null', which stands for nil in the tables
+// converted from Go containers. This also applies to the elements of slices
+// and the fields of structs, so that optional values can be told apart from
+// zero values.
 //
 // The Go value can be an interface, in which case the type is inferred. When
 // converting a table to an interface, the Go value is a []interface{} slice if
 // all its elements are indexed consecutively from 1, or a
-// map[string]interface{} otherwise.
+// map[string]interface{} otherwise. Nested tables are converted the same way,
+// so the result holds no Lua value and can be encoded with encoding/json. Empty
+// tables become empty slices. Tables naming a Go type in their "__type" field
+// are converted to that type instead: see RegisterTypeName.
+//
+// Lua numbers and tables can be converted to time.Time: see TimeAsUnix.
+//
+// Lua strings can be converted to encoding.TextUnmarshaler values: see
+// TextMarshalers.
+//
+// Custom conversions can be registered: see RegisterConverter.
+//
+// Lua sequences can be converted to channels: see TableChannels.
+//
+// Tables of the form {re=x, im=y} or {x, y} can be converted to complex numbers.
+//
+// Lua strings can be converted to byte slices, and to errors with errors.New.
+//
+// Table keys are matched to the Lua names of struct fields, see FoldFieldNames.
+// Unknown keys are ignored, unless StrictStructs is set.
 //
 // Existing entries in maps and structs are kept. Arrays and slices are reset.
 //
@@ -723,6 +1400,10 @@ func copyTableToStruct(L *lua.State, idx int, v reflect.Value, visited map[uintp
 // pointer.
 // Userdata that is not a proxy will be converted to a LuaObject if the Go value
 // is an interface or a LuaObject.
+//
+// Lua functions are converted to a LuaObject if the Go value is an interface or
+// a LuaObject. If the Go value is a function, it is set to a Go function that
+// calls the Lua function.
 func LuaToGo(L *lua.State, idx int, a interface{}) error {
 	// LuaToGo should not pop the Lua stack to be consistent with L.ToString(), etc.
 	// It is also easier in practice when we want to keep working with the value on stack.
@@ -737,14 +1418,39 @@ func LuaToGo(L *lua.State, idx int, a interface{}) error {
 		return errors.New("nil pointer")
 	}
 
-	v = v.Elem()
-	// If the Lua value is 'nil' and the Go value is a pointer, nullify the pointer.
-	if v.Kind() == reflect.Ptr && L.IsNil(idx) {
-		v.Set(reflect.Zero(v.Type()))
-		return nil
+	return luaToGo(L, idx, v.Elem(), map[uintptr]reflect.Value{})
+}
+
+// LuaToGoStream converts the elements of the Lua sequence at 'idx' to Go
+// values of type 'elemType', one at a time, and passes them to 'yield'. Unlike
+// LuaToGo into a slice, the sequence is never held in memory as a whole, which
+// suits the processing of large tables.
+//
+// The elements are converted as by LuaToGo. The walk stops at the first
+// conversion error, or at the first error returned by 'yield', which is then
+// returned. 'yield' must leave the Lua stack as it found it.
+func LuaToGoStream(L *lua.State, idx int, elemType reflect.Type, yield func(reflect.Value) error) error {
+	if !L.IsTable(idx) {
+		return ConvError{From: luaDesc(L, idx), To: reflect.SliceOf(elemType)}
+	}
+	if idx < 0 && idx > lua.LUA_REGISTRYINDEX {
+		idx = L.GetTop() + idx + 1
 	}
 
-	return luaToGo(L, idx, v, map[uintptr]reflect.Value{})
+	n := int(L.ObjLen(idx))
+	for i := 1; i <= n; i++ {
+		L.RawGeti(idx, i)
+		val := reflect.New(elemType).Elem()
+		err := luaToGo(L, -1, val, map[uintptr]reflect.Value{})
+		L.Pop(1)
+		if err != nil {
+			return err
+		}
+		if err := yield(val); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func luaToGo(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect.Value) error {
@@ -753,6 +1459,13 @@ func luaToGo(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect
 	// fails.
 	// This must be done here and not in LuaToGo so that the copyTable* functions
 	// can also call luaToGo on pointers.
+	// An explicit 'nil' nullifies pointers instead, and so does luar.null, which
+	// stands for nil pointers in containers. A missing value is an error.
+	if v.Kind() == reflect.Ptr && (L.IsNil(idx) || isNullProxy(L, idx)) {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
 	vp := v
 	for v.Kind() == reflect.Ptr {
 		if v.IsNil() {
@@ -763,10 +1476,28 @@ func luaToGo(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect
 	}
 	kind := v.Kind()
 
+	if ok, err := convertFromLua(L, idx, v); ok {
+		return err
+	}
+
+	if TextMarshalers {
+		if ok, err := unmarshalText(L, idx, v); ok {
+			return err
+		}
+	}
+
+	if v.Type() == ttime && (L.IsNumber(idx) || L.IsTable(idx)) {
+		return luaToTime(L, idx, v)
+	}
+
 	switch L.Type(idx) {
 	case lua.LUA_TNIL:
 		v.Set(reflect.Zero(v.Type()))
 	case lua.LUA_TBOOLEAN:
+		if kind == reflect.String && LenientStrings {
+			v.SetString(fmt.Sprint(L.ToBoolean(idx)))
+			break
+		}
 		if kind != reflect.Bool && kind != reflect.Interface {
 			return ConvError{From: luaDesc(L, idx), To: v.Type()}
 		}
@@ -774,16 +1505,48 @@ func luaToGo(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect
 	case lua.LUA_TNUMBER:
 		switch k := unsizedKind(v); k {
 		case reflect.Int64, reflect.Uint64, reflect.Float64, reflect.Interface:
+			if k == reflect.Interface && PreferIntForWholeNumbers {
+				if i, ok := wholeNumber(L, idx); ok {
+					v.Set(reflect.ValueOf(i))
+					break
+				}
+			}
+			if k == reflect.Int64 || k == reflect.Uint64 {
+				if CheckRanges && integerOverflows(L, idx, v) {
+					return ConvError{From: luaDesc(L, idx), To: v.Type()}
+				}
+				if i, ok := luaToInteger(L, idx); ok {
+					v.Set(reflect.ValueOf(i).Convert(v.Type()))
+					break
+				}
+			}
 			// We do not use ToInteger as it may truncate the value. Let Go truncate
 			// instead in Convert().
 			f := reflect.ValueOf(L.ToNumber(idx))
 			v.Set(f.Convert(v.Type()))
 		case reflect.Complex128:
 			v.SetComplex(complex(L.ToNumber(idx), 0))
+		case reflect.String:
+			if !LenientStrings {
+				return ConvError{From: luaDesc(L, idx), To: v.Type()}
+			}
+			if i, ok := wholeNumber(L, idx); ok {
+				v.SetString(fmt.Sprint(i))
+			} else {
+				v.SetString(fmt.Sprint(L.ToNumber(idx)))
+			}
 		default:
 			return ConvError{From: luaDesc(L, idx), To: v.Type()}
 		}
 	case lua.LUA_TSTRING:
+		if kind == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(L.ToString(idx)))
+			break
+		}
+		if v.Type() == terror {
+			v.Set(reflect.ValueOf(errors.New(L.ToString(idx))))
+			break
+		}
 		if kind != reflect.String && kind != reflect.Interface {
 			return ConvError{From: luaDesc(L, idx), To: v.Type()}
 		}
@@ -856,7 +1619,17 @@ func luaToGo(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect
 			return copyTableToMap(L, idx, v, visited)
 		case reflect.Struct:
 			return copyTableToStruct(L, idx, v, visited)
+		case reflect.Complex64, reflect.Complex128:
+			return luaToComplex(L, idx, v)
+		case reflect.Chan:
+			if !TableChannels || v.Type().ChanDir()&reflect.RecvDir == 0 {
+				return ConvError{From: luaDesc(L, idx), To: v.Type()}
+			}
+			return copyTableToChan(L, idx, v, visited)
 		case reflect.Interface:
+			if ok, err := copyTableToNamedType(L, idx, v, visited); ok {
+				return err
+			}
 			n := int(L.ObjLen(idx))
 
 			switch v.Elem().Kind() {
@@ -868,6 +1641,11 @@ func luaToGo(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect
 				return copyTableToSlice(L, idx, v.Elem(), visited)
 			}
 
+			if v.Type().NumMethod() > 0 {
+				// The maps and slices below have no methods.
+				return ConvError{From: luaDesc(L, idx), To: v.Type()}
+			}
+
 			if luaMapLen(L, idx) != n {
 				v.Set(reflect.MakeMap(tmap))
 				return copyTableToMap(L, idx, v.Elem(), visited)
@@ -882,6 +1660,8 @@ func luaToGo(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect
 			v.Set(reflect.ValueOf(NewLuaObject(L, idx)))
 		} else if vp.Type() == reflect.TypeOf(&LuaObject{}) {
 			vp.Set(reflect.ValueOf(NewLuaObject(L, idx)))
+		} else if kind == reflect.Func {
+			v.Set(luaToGoFunction(L, idx, v.Type()))
 		} else {
 			return ConvError{From: luaDesc(L, idx), To: v.Type()}
 		}
@@ -892,6 +1672,188 @@ func luaToGo(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect
 	return nil
 }
 
+// luaToGoFunction wraps the Lua function at 'idx' into a Go function of type
+// 't'. The arguments are proxified and the results converted with LuaToGo.
+//
+// If the last result of 't' is an error, a non-nil Lua value at this position
+// is returned as an error message. Conversion and runtime errors are returned
+// there as well, otherwise the Go function panics.
+//
+// The Lua function is referenced from the registry so that it does not get
+// collected while the Go function is in use. Once the Go function is collected,
+// the reference is released on the next call into the state, see
+// unrefCollected.
+func luaToGoFunction(L *lua.State, idx int, t reflect.Type) reflect.Value {
+	unrefCollected(L)
+	h := &funcRef{lo: NewLuaObject(L, idx)}
+	runtime.SetFinalizer(h, queueFuncRef)
+	nout := t.NumOut()
+	hasError := nout > 0 && t.Out(nout-1) == terror
+
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		results := make([]reflect.Value, nout)
+		for i := range results {
+			results[i] = reflect.Zero(t.Out(i))
+		}
+		fail := func(err error) []reflect.Value {
+			if !hasError {
+				panic(err)
+			}
+			results[nout-1] = reflect.ValueOf(&err).Elem()
+			return results
+		}
+
+		L := h.lo.l
+		h.lo.Push()
+		if t.IsVariadic() && len(args) > 0 {
+			last := args[len(args)-1]
+			args = args[:len(args)-1]
+			for i := 0; i < last.Len(); i++ {
+				args = append(args, last.Index(i))
+			}
+		}
+		for _, arg := range args {
+			GoToLuaProxy(L, arg)
+		}
+		err := L.Call(len(args), nout)
+		if err != nil {
+			L.Pop(1)
+			return fail(err)
+		}
+		defer L.Pop(nout)
+
+		for i := 0; i < nout; i++ {
+			if hasError && i == nout-1 {
+				// A non-nil value in the error position is the error message.
+				if !L.IsNil(-1) {
+					err := errors.New(luaToString(L, -1))
+					results[i] = reflect.ValueOf(&err).Elem()
+				}
+				break
+			}
+			val := reflect.New(t.Out(i))
+			err := LuaToGo(L, i-nout, val.Interface())
+			if err != nil {
+				return fail(err)
+			}
+			results[i] = val.Elem()
+		}
+		return results
+	})
+}
+
+// funcRef holds the Lua function of a Go function made by luaToGoFunction. Only
+// the Go function refers to it, so its finalizer runs once the Go function is
+// unreachable.
+type funcRef struct {
+	lo *LuaObject
+}
+
+var (
+	// collectedRefs holds the registry references of the collected funcRefs by
+	// state. They cannot be released from the finalizers, which run in another
+	// goroutine.
+	collectedRefs   = map[*lua.State][]int{}
+	collectedRefsMu sync.Mutex
+	// collectedLen is the number of states in collectedRefs, read without the
+	// lock so that calls do not contend on it when there is nothing to release.
+	collectedLen int32
+)
+
+func queueFuncRef(h *funcRef) {
+	collectedRefsMu.Lock()
+	collectedRefs[h.lo.l] = append(collectedRefs[h.lo.l], h.lo.ref)
+	atomic.StoreInt32(&collectedLen, int32(len(collectedRefs)))
+	collectedRefsMu.Unlock()
+}
+
+// unrefCollected releases the registry references of the collected Go functions
+// made in 'L'.
+func unrefCollected(L *lua.State) {
+	if atomic.LoadInt32(&collectedLen) == 0 {
+		return
+	}
+	collectedRefsMu.Lock()
+	refs := collectedRefs[L]
+	delete(collectedRefs, L)
+	atomic.StoreInt32(&collectedLen, int32(len(collectedRefs)))
+	collectedRefsMu.Unlock()
+	for _, ref := range refs {
+		L.Unref(lua.LUA_REGISTRYINDEX, ref)
+	}
+}
+
+// luaToComplex converts a table of the form {re=x, im=y} or {x, y} to the
+// complex value 'v'. Missing parts default to 0.
+func luaToComplex(L *lua.State, idx int, v reflect.Value) error {
+	var parts [2]float64
+	for i, name := range [...]string{"re", "im"} {
+		L.GetField(idx, name)
+		if L.IsNil(-1) {
+			L.Pop(1)
+			L.RawGeti(idx, i+1)
+		}
+		if !L.IsNil(-1) && L.Type(-1) != lua.LUA_TNUMBER {
+			L.Pop(1)
+			return ConvError{From: luaDesc(L, idx), To: v.Type()}
+		}
+		parts[i] = L.ToNumber(-1)
+		L.Pop(1)
+	}
+	v.SetComplex(complex(parts[0], parts[1]))
+	return nil
+}
+
+// luaToInteger returns the value at 'idx' as an integer if the Lua runtime
+// stores it as such. This avoids the precision loss of the float conversion for
+// integers beyond 2^53.
+func luaToInteger(L *lua.State, idx int) (int64, bool) {
+	if !luaHasInteger {
+		return 0, false
+	}
+	// On Lua >= 5.3, lua_tointeger returns 0 for floats with a fractional part.
+	// Integers and integral floats both round-trip through float64.
+	i := int64(L.ToInteger(idx))
+	if float64(i) != L.ToNumber(idx) {
+		return 0, false
+	}
+	return i, true
+}
+
+// wholeNumber returns the Lua number at 'idx' as an int64 if it has no
+// fractional part and fits.
+func wholeNumber(L *lua.State, idx int) (int64, bool) {
+	if i, ok := luaToInteger(L, idx); ok {
+		return i, true
+	}
+	f := L.ToNumber(idx)
+	if f != math.Trunc(f) || f < -(1<<63) || f >= 1<<63 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// integerOverflows reports whether the Lua number at 'idx' is out of the range
+// of the integer value 'v' once truncated.
+func integerOverflows(L *lua.State, idx int, v reflect.Value) bool {
+	signed := unsizedKind(v) == reflect.Int64
+	if i, ok := luaToInteger(L, idx); ok {
+		if signed {
+			return v.OverflowInt(i)
+		}
+		return i < 0 || v.OverflowUint(uint64(i))
+	}
+	// The bounds are powers of two, hence exact in float64, and the lower one is
+	// inclusive. Written so that NaN overflows.
+	f := math.Trunc(L.ToNumber(idx))
+	bits := v.Type().Bits()
+	if signed {
+		limit := math.Ldexp(1, bits-1)
+		return !(f >= -limit && f < limit)
+	}
+	return !(f >= 0 && f < math.Ldexp(1, bits))
+}
+
 func isNewType(t reflect.Type) bool {
 	types := [...]reflect.Type{
 		reflect.Invalid:    nil, // Invalid Kind = iota
@@ -930,10 +1892,23 @@ func isNewType(t reflect.Type) bool {
 //
 // See GoToLuaProxy's documentation.
 func Register(L *lua.State, table string, values Map) {
-	pop := true
+	pop := pushRegisterTable(L, table)
+	for name, val := range values {
+		GoToLuaProxy(L, val)
+		L.SetField(-2, name)
+	}
+	if pop {
+		L.Pop(1)
+	}
+}
+
+// pushRegisterTable pushes the table designated by 'table' as in Register,
+// unless it is '*'. It returns whether the table must be popped.
+func pushRegisterTable(L *lua.State, table string) bool {
 	if table == "*" {
-		pop = false
-	} else if len(table) > 0 {
+		return false
+	}
+	if len(table) > 0 {
 		L.GetGlobal(table)
 		if L.IsNil(-1) {
 			L.Pop(1)
@@ -944,13 +1919,506 @@ func Register(L *lua.State, table string, values Map) {
 	} else {
 		L.GetGlobal("_G")
 	}
+	return true
+}
+
+// RegisterAlias makes the value registered as 'canonical' in 'table' available
+// under the names 'aliases' as well, e.g. to keep the legacy name of a function.
+// The aliases refer to the same Lua value, so functions are not wrapped again
+// and compare equal. 'table' is interpreted as in Register.
+//
+// An error is returned if 'canonical' is nil.
+func RegisterAlias(L *lua.State, table string, canonical string, aliases ...string) error {
+	pop := pushRegisterTable(L, table)
+	L.GetField(-1, canonical)
+	if L.IsNil(-1) {
+		L.Pop(1)
+		if pop {
+			L.Pop(1)
+		}
+		return fmt.Errorf("no value registered as `%s`", canonical)
+	}
+	for _, alias := range aliases {
+		L.PushValue(-1)
+		L.SetField(-3, alias)
+	}
+	L.Pop(1)
+	if pop {
+		L.Pop(1)
+	}
+	return nil
+}
+
+// RegisterAll merges 'maps' and registers the result with Register, e.g. to
+// compose the bindings of several packages in a single table.
+//
+// If StrictRegister is set and several maps define the same name with different
+// values, an error is returned and nothing is registered. Functions are the
+// same if they have the same code, so distinct closures of a function literal
+// are not told apart.
+func RegisterAll(L *lua.State, table string, maps ...Map) error {
+	merged := Map{}
+	origin := map[string]int{}
+	var collisions []string
+	for i, m := range maps {
+		for name, val := range m {
+			if prev, ok := merged[name]; ok && StrictRegister && !sameValue(prev, val) {
+				collisions = append(collisions, fmt.Sprintf("`%s` (maps #%d and #%d)", name, origin[name]+1, i+1))
+				continue
+			}
+			merged[name] = val
+			origin[name] = i
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return fmt.Errorf("conflicting definitions of %s", strings.Join(collisions, ", "))
+	}
+	Register(L, table, merged)
+	return nil
+}
+
+// sameValue reports whether 'a' and 'b' are the same value for RegisterAll.
+func sameValue(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() {
+		return va.IsValid() == vb.IsValid()
+	}
+	if va.Type() != vb.Type() {
+		return false
+	}
+	if va.Kind() == reflect.Func {
+		return va.Pointer() == vb.Pointer()
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// RegisterConstants makes a number of Go values available in Lua code as the
+// read-only fields of the global table 'table', e.g. to group enum values.
+// Unlike Register, the values are converted with GoToLua and assigning to a
+// field raises an error. The table can still be iterated with 'pairs'.
+//
+// 'table' must be a non-empty name. An existing global of that name is
+// replaced.
+func RegisterConstants(L *lua.State, table string, values Map) {
+	L.CreateTable(0, len(values))
 	for name, val := range values {
-		GoToLuaProxy(L, val)
+		GoToLua(L, val)
 		L.SetField(-2, name)
 	}
-	if pop {
+
+	L.NewTable()
+	L.NewTable()
+	L.PushValue(-3)
+	L.SetField(-2, "__index")
+	L.PushGoFunction(constants__newindex)
+	L.SetField(-2, "__newindex")
+	L.PushGoFunction(constants__pairs)
+	L.SetField(-2, "__pairs")
+	L.PushBoolean(false)
+	L.SetField(-2, "__metatable")
+	L.SetMetaTable(-2)
+
+	L.SetGlobal(table)
+	L.Pop(1)
+}
+
+func constants__newindex(L *lua.State) int {
+	L.RaiseError(fmt.Sprintf("cannot assign to constant `%s`", L.ToString(2)))
+	return 0
+}
+
+func constants__pairs(L *lua.State) int {
+	L.GetGlobal("next")
+	L.GetMetaField(1, "__index")
+	L.PushNil()
+	return 3
+}
+
+// property is the Go side of a property registered with RegisterProperty.
+type property struct {
+	get func() interface{}
+	set func(interface{}) error
+}
+
+const (
+	propertiesKey   = "luar.properties"
+	propIndexKey    = "luar.index"
+	propNewIndexKey = "luar.newindex"
+)
+
+// RegisterProperty makes 'name' a computed field of the global table 'table',
+// or of the global table (_G) itself if 'table' is ''. Reading the field returns
+// the result of 'get' as a proxy, see GoToLuaProxy, and assigning to it calls
+// 'set' with the value converted by LuaToGo. The error returned by 'set' is
+// raised in Lua. If 'set' is nil, the property is read-only.
+//
+// The properties are implemented by '__index' and '__newindex' metamethods
+// set on the table. Its existing metatable is kept: the other fields are
+// looked up and assigned through its former metamethods.
+func RegisterProperty(L *lua.State, table, name string, get func() interface{}, set func(interface{}) error) {
+	if len(table) > 0 {
+		L.GetGlobal(table)
+		if L.IsNil(-1) {
+			L.Pop(1)
+			L.NewTable()
+			L.SetGlobal(table)
+			L.GetGlobal(table)
+		}
+	} else {
+		L.GetGlobal("_G")
+	}
+
+	// A raw field would hide the property.
+	L.PushString(name)
+	L.PushNil()
+	L.RawSet(-3)
+
+	pushProperties(L)
+	GoToLuaProxy(L, &property{get: get, set: set})
+	L.SetField(-2, name)
+	L.Pop(2)
+}
+
+// pushProperties pushes the table of the properties of the table on top of the
+// stack, installing the property metamethods on first use.
+func pushProperties(L *lua.State) {
+	if !L.GetMetaTable(-1) {
+		L.NewTable()
+		L.PushValue(-1)
+		L.SetMetaTable(-3)
+	}
+	L.GetField(-1, propertiesKey)
+	if L.IsNil(-1) {
 		L.Pop(1)
+		L.GetField(-1, "__index")
+		L.SetField(-2, propIndexKey)
+		L.GetField(-1, "__newindex")
+		L.SetField(-2, propNewIndexKey)
+		L.PushGoFunction(property__index)
+		L.SetField(-2, "__index")
+		L.PushGoFunction(property__newindex)
+		L.SetField(-2, "__newindex")
+		L.NewTable()
+		L.PushValue(-1)
+		L.SetField(-3, propertiesKey)
+	}
+	L.Remove(-2)
+}
+
+// propertyOf returns the property of the table at index 1 named by the key at
+// index 2, or nil. It leaves the metatable of the table on the stack.
+func propertyOf(L *lua.State) *property {
+	L.GetMetaTable(1)
+	L.GetField(-1, propertiesKey)
+	L.PushValue(2)
+	L.RawGet(-2)
+	defer L.Pop(2)
+	if !isValueProxy(L, -1) {
+		return nil
+	}
+	v, _ := valueOfProxy(L, -1)
+	p, _ := v.Interface().(*property)
+	return p
+}
+
+func property__index(L *lua.State) int {
+	if p := propertyOf(L); p != nil {
+		GoToLuaProxy(L, p.get())
+		return 1
+	}
+
+	L.GetField(-1, propIndexKey)
+	switch {
+	case L.IsFunction(-1):
+		L.PushValue(1)
+		L.PushValue(2)
+		if err := L.Call(2, 1); err != nil {
+			L.RaiseError(err.Error())
+		}
+	case !L.IsNil(-1):
+		L.PushValue(2)
+		L.GetTable(-2)
+	}
+	return 1
+}
+
+func property__newindex(L *lua.State) int {
+	if p := propertyOf(L); p != nil {
+		if p.set == nil {
+			L.RaiseError(fmt.Sprintf("property `%s` is read-only", L.ToString(2)))
+		}
+		var val interface{}
+		if err := LuaToGo(L, 3, &val); err != nil {
+			L.RaiseError(err.Error())
+		}
+		if err := p.set(val); err != nil {
+			L.RaiseError(err.Error())
+		}
+		return 0
+	}
+
+	L.GetField(-1, propNewIndexKey)
+	switch {
+	case L.IsFunction(-1):
+		L.PushValue(1)
+		L.PushValue(2)
+		L.PushValue(3)
+		if err := L.Call(3, 0); err != nil {
+			L.RaiseError(err.Error())
+		}
+	case !L.IsNil(-1):
+		L.PushValue(2)
+		L.PushValue(3)
+		L.SetTable(-3)
+	default:
+		L.PushValue(2)
+		L.PushValue(3)
+		L.RawSet(1)
+	}
+	return 0
+}
+
+// Namespace returns the sorted string keys of the global table 'table', e.g. to
+// list what was registered in it with Register. If 'table' is '', the keys of
+// the global table (_G) are returned.
+func Namespace(L *lua.State, table string) ([]string, error) {
+	if table == "" {
+		table = "_G"
+	}
+	L.GetGlobal(table)
+	defer L.Pop(1)
+	if !L.IsTable(-1) {
+		return nil, fmt.Errorf("%s is not a table", table)
+	}
+
+	var names []string
+	L.PushNil()
+	for L.Next(-2) != 0 {
+		if L.Type(-2) == lua.LUA_TSTRING {
+			names = append(names, L.ToString(-2))
+		}
+		L.Pop(1)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ErrorOption configures RegisterWithErrors.
+type ErrorOption func(*errorOptions)
+
+type errorOptions struct {
+	values bool
+}
+
+// WithErrorValues makes the functions raise their errors as proxies to the Go
+// error values instead of messages. The original error then survives 'pcall'
+// and being re-thrown with 'error': Go code calling the script gets a *LuaError
+// that unwraps to it, so errors.Is and errors.As work across the boundary.
+// 'tostring' gives the message and 'luar.errorvalue' tells Go errors from other
+// error values.
+//
+// Scripts may expect error messages, e.g. to match them with string methods, so
+// this is not the default. The functions are also wrapped in a Lua function to
+// raise the errors, which makes calls slightly slower.
+func WithErrorValues() ErrorOption {
+	return func(o *errorOptions) {
+		o.values = true
+	}
+}
+
+// RegisterWithErrors is like Register, except that the Go functions in 'values'
+// raise a Lua error when their last result is a non-nil error. The other
+// results are dropped in that case. This lets scripts handle Go errors with
+// 'pcall'. The error value is the message, unless WithErrorValues is passed.
+func RegisterWithErrors(L *lua.State, table string, values Map, opts ...ErrorOption) {
+	var o errorOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	wrapped := make(Map, len(values))
+	for name, val := range values {
+		v := reflect.ValueOf(val)
+		if v.Kind() == reflect.Func {
+			switch val.(type) {
+			case func(*lua.State) int, lua.LuaGoFunction:
+				// Raw Lua functions handle errors themselves.
+			default:
+				if o.values {
+					lo := wrapErrorValues(L, goToLuaFunction(L, v, errorsAsValues))
+					defer lo.Close()
+					val = lo
+				} else {
+					val = (func(*lua.State) int)(goToLuaFunction(L, v, errorsRaised))
+				}
+			}
+		}
+		wrapped[name] = val
+	}
+	Register(L, table, wrapped)
+}
+
+const (
+	errorMarkerKey       = "luar.errormarker"
+	errorValuesHelperKey = "luar.errorvalues"
+)
+
+// errorValuesHelper wraps a function so that the error value it returns after
+// the marker is raised. Raising it from Go would unwind the Go stack, which cgo
+// does not allow.
+const errorValuesHelper = `
+local function check(marker, ...)
+	if rawequal((...), marker) then
+		error((select(2, ...)), 0)
+	end
+	return ...
+end
+return function(f, marker)
+	return function(...)
+		return check(marker, f(...))
+	end
+end
+`
+
+// pushErrorMarker pushes the table whose first position in the results of a
+// function marks an error to raise, see errorsAsValues.
+func pushErrorMarker(L *lua.State) {
+	L.GetField(lua.LUA_REGISTRYINDEX, errorMarkerKey)
+	if !L.IsNil(-1) {
+		return
+	}
+	L.Pop(1)
+	L.NewTable()
+	L.PushValue(-1)
+	L.SetField(lua.LUA_REGISTRYINDEX, errorMarkerKey)
+}
+
+// wrapErrorValues returns a Lua function calling 'f' and raising the error
+// values it returns in errorsAsValues mode.
+func wrapErrorValues(L *lua.State, f lua.LuaGoFunction) *LuaObject {
+	pushLuaHelper(L, errorValuesHelperKey, errorValuesHelper)
+	L.PushGoFunction(f)
+	pushErrorMarker(L)
+	L.MustCall(2, 1)
+	defer L.Pop(1)
+	return NewLuaObject(L, -1)
+}
+
+// ErrorsAsMessages wraps the Go function 'f' so that it follows the Lua
+// convention for errors: when its last result is a non-nil error, the other
+// results are replaced by nil and the error by its message. On success, the last
+// result is nil.
+//
+// The returned function can be registered with Register or RegisterWithErrors
+// to select this behaviour per function.
+func ErrorsAsMessages(L *lua.State, f interface{}) func(*lua.State) int {
+	return goToLuaFunction(L, reflect.ValueOf(f), errorsAsMessages)
+}
+
+// RegisterMethods makes the exported methods of 'obj' available in Lua code.
+// The methods are bound to 'obj' and registered by name into 'table', as with
+// Register.
+//
+// If 'obj' is not a pointer, it is copied so that methods with a pointer
+// receiver can be registered too. These methods then act on the copy.
+func RegisterMethods(L *lua.State, table string, obj interface{}) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr {
+		vp := reflect.New(v.Type())
+		vp.Elem().Set(v)
+		v = vp
+	}
+	t := v.Type()
+	methods := make(Map, t.NumMethod())
+	for i := 0; i < t.NumMethod(); i++ {
+		methods[t.Method(i).Name] = v.Method(i).Interface()
 	}
+	Register(L, table, methods)
+}
+
+// RegisterType makes the type of 'proto' available in Lua code as a global
+// table 'name' with the following functions:
+//
+// - new(...): Return a proxy to a new value of that type. For structs, the
+// arguments are assigned to the exported fields in order and the proxy wraps a
+// pointer to the struct. For other types, the optional argument is converted to
+// the value.
+//
+// - is(x): Return true if 'x' is a proxy to a value of that type or to a
+// pointer to such a value.
+//
+// For structs, the proxies to values of that type or to pointers to them then
+// use the metatable 'name' of the registry. Besides the usual metamethods, it
+// holds '__name' and the methods of the type, which take the proxy as their
+// first argument, e.g. 'getmetatable(p).GetName(p)'.
+//
+// If 'proto' is a pointer, the pointed type is registered.
+func RegisterType(L *lua.State, name string, proto interface{}) {
+	t := reflect.TypeOf(proto)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Only exported fields can be set.
+	var fields []int
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				fields = append(fields, i)
+			}
+		}
+	}
+
+	newValue := func(L *lua.State) int {
+		vp := reflect.New(t)
+		n := L.GetTop()
+		if t.Kind() == reflect.Struct {
+			if n > len(fields) {
+				L.RaiseError(fmt.Sprintf("too many arguments to %v constructor", t))
+			}
+			for i := 1; i <= n; i++ {
+				f := vp.Elem().Field(fields[i-1])
+				err := LuaToGo(L, i, f.Addr().Interface())
+				if err != nil {
+					L.RaiseError(fmt.Sprintf("cannot convert %v constructor argument #%v: %v", t, i, err))
+				}
+			}
+			// Pass structs by reference so that methods with pointer receivers can
+			// be called.
+			GoToLuaProxy(L, vp)
+			return 1
+		}
+		if n > 0 {
+			err := LuaToGo(L, 1, vp.Interface())
+			if err != nil {
+				L.RaiseError(fmt.Sprintf("cannot convert %v constructor argument: %v", t, err))
+			}
+		}
+		GoToLuaProxy(L, vp.Elem())
+		return 1
+	}
+
+	isValue := func(L *lua.State) int {
+		if !isValueProxy(L, 1) {
+			L.PushBoolean(false)
+			return 1
+		}
+		_, typ := valueOfProxy(L, 1)
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		L.PushBoolean(typ == t)
+		return 1
+	}
+
+	if t.Kind() == reflect.Struct {
+		registerTypeMeta(L, name, t)
+	}
+
+	Register(L, name, Map{
+		"new": newValue,
+		"is":  isValue,
+	})
 }
 
 // Closest we'll get to a typeof operator.
@@ -1,6 +1,14 @@
 package luar
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
 	"reflect"
 	"runtime"
 	"sort"
@@ -8,6 +16,8 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
+	"unicode"
 
 	"github.com/aarzilli/golua/lua"
 )
@@ -192,9 +202,100 @@ func TestArray(t *testing.T) {
 	mustDoString(t, L, `a[2] = 180`)
 	runGoTest(t, L, []goTestData{
 		{`a`, [2]int{17, 180}, ""},
-		{`a`, [1]int{17}, ""},
+		{`a`, [1]int{17}, ErrTableConv.Error()},
 		{`a`, [3]int{17, 180, 0}, ""},
 	})
+
+	GoToLua(L, [3]int{1, 2, 3})
+	L.SetGlobal("b")
+	runLuaTest(t, L, []luaTestData{{`#b`, `3`}, {`b`, `{1, 2, 3}`}})
+	runGoTest(t, L, []goTestData{{`b`, [3]int{1, 2, 3}, ""}})
+
+	ZeroFillArrays = false
+	defer func() { ZeroFillArrays = true }()
+	runGoTest(t, L, []goTestData{
+		{`b`, [3]int{1, 2, 3}, ""},
+		{`a`, [3]int{}, ErrTableConv.Error()},
+	})
+}
+
+func TestBind(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{
+		"greet": func(greeting, name string) string { return greeting + ", " + name },
+		"count": func(args ...interface{}) int { return len(args) },
+		"sum": func(xs []int, k int) int {
+			for _, x := range xs {
+				k += x
+			}
+			return k
+		},
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`luar.bind(greet, "hello")("world")`, `"hello, world"`},
+		{`luar.bind(greet, "hello", "you")()`, `"hello, you"`},
+		{`luar.bind(count, nil, nil)(nil)`, `3`},
+		{`luar.bind(function(...) return ... end, 1)(2, 3)`, `1`},
+		{`select('#', luar.bind(function(...) return ... end, 1)(2, 3))`, `3`},
+		{`pcall(luar.bind)`, `false`},
+		{`pcall(luar.bind, {})`, `false`},
+		{`pcall(luar.bind, sum, {'x'})`, `false`},
+		{`luar.bind(setmetatable({}, {__call = function(_, a, b) return a .. b end}), 'x')('y')`, `'xy'`},
+	})
+
+	// Tables bound to Go functions are converted once.
+	mustDoString(t, L, `xs = {1, 2}; f = luar.bind(sum, xs); xs[1] = 10`)
+	runLuaTest(t, L, []luaTestData{
+		{`f(0)`, `3`},
+		{`f(1)`, `4`},
+	})
+
+	// Bound values are kept alive by the function.
+	mustDoString(t, L, `hello = luar.bind(greet, "hello"); collectgarbage()`)
+	runLuaTest(t, L, []luaTestData{
+		{`hello("again")`, `"hello, again"`},
+	})
+}
+
+func TestBytes(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	b := []byte("foo")
+	Register(L, "", Map{
+		"b":      b,
+		"concat": func(a, b []byte) []byte { return append(a, b...) },
+	})
+	runLuaTest(t, L, []luaTestData{
+		{`b`, `'foo'`},
+		{`type(b)`, `'string'`},
+		{`concat(b, "bar")`, `'foobar'`},
+	})
+
+	runGoTest(t, L, []goTestData{
+		{`"bar"`, []byte("bar"), ""},
+		{`""`, []byte{}, ""},
+		{`{98, 97, 114}`, []byte("bar"), ""},
+	})
+
+	BytesAsString = false
+	defer func() { BytesAsString = true }()
+
+	GoToLuaProxy(L, b)
+	L.SetGlobal("bp")
+	GoToLua(L, b)
+	L.SetGlobal("bt")
+	mustDoString(t, L, `bp[1] = 70`)
+	runLuaTest(t, L, []luaTestData{
+		{`type(bp)`, `'table<[]uint8>'`},
+		{`bt`, `{102, 111, 111}`},
+	})
+	if string(b) != "Foo" {
+		t.Errorf("got %q, want %q", b, "Foo")
+	}
 }
 
 func TestChan(t *testing.T) {
@@ -226,6 +327,82 @@ func TestChan(t *testing.T) {
 	wg.Wait()
 	checkStack(t, L1)
 	checkStack(t, L2)
+
+	b := make(chan int, 2)
+	Register(L1, "", Map{"b": b})
+	mustDoString(t, L1, `
+b.send(17)
+b.send(18)
+r1 = {b.receive()}
+b.close()
+r2 = {b.receive()}
+r3 = {b.receive()}
+n3 = select("#", b.receive())
+ok4 = pcall(b.send, 19)
+ok5 = pcall(b.close)
+`)
+	runLuaTest(t, L1, []luaTestData{
+		{`r1`, `{17, true}`},
+		{`r2`, `{18, true}`},
+		{`r3`, `{nil, false}`},
+		{`n3`, `2`},
+		{`ok4`, `false`},
+		{`ok5`, `false`},
+	})
+
+	// Select the ready channel.
+	idle := make(chan int)
+	ready := make(chan string, 1)
+	ready <- "go"
+	full := make(chan int)
+	out := make(chan int, 1)
+	Register(L1, "", Map{"idle": idle, "ready": ready, "full": full, "out": out})
+	runLuaTest(t, L1, []luaTestData{
+		{`{luar.select({{chan=idle}, {chan=ready}})}`, `{2, "go", true}`},
+		{`{luar.select({{chan=idle}, {chan=ready}, {default=true}})}`, `{3}`},
+		{`{luar.select({{chan=full, send=1}, {chan=out, send=17}})}`, `{2}`},
+		{`{luar.select({{chan=idle}, {chan=b}})}`, `{2, nil, false}`},
+		{`pcall(luar.select, {{chan=out, send="foo"}})`, `false`},
+		{`pcall(luar.select, {{send=1}})`, `false`},
+	})
+	if got := <-out; got != 17 {
+		t.Errorf("got %v, want 17", got)
+	}
+	checkStack(t, L1)
+}
+
+func TestClone(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	s := [][]int{{1, 2}, {3}}
+	m := map[string][]string{"foo": {"bar"}}
+	p := &person{Name: "Alice", Age: 16}
+	Register(L, "", Map{"s": s, "m": m, "p": p})
+
+	mustDoString(t, L, `
+sc = luar.clone(s)
+sc[1][1] = 10
+mc = luar.clone(m)
+mc.foo[1] = "baz"
+mc.qux = {"x"}
+pc = luar.clone(p)
+pc.Name = "Bob"
+`)
+	runLuaTest(t, L, []luaTestData{
+		{`sc[1][1]`, `10`},
+		{`s[1][1]`, `1`},
+		{`type(sc)`, `'table<[][]int>'`},
+		{`mc.foo[1]`, `'baz'`},
+		{`m.qux`, `nil`},
+		{`pc.Name`, `'Bob'`},
+		{`type(pc)`, `'table<*luar.person>'`},
+		{`luar.clone(17)`, `17`},
+	})
+
+	if s[0][0] != 1 || m["foo"][0] != "bar" || len(m) != 1 || p.Name != "Alice" {
+		t.Errorf("original values modified: %v, %v, %v", s, m, p)
+	}
 }
 
 func TestComplex(t *testing.T) {
@@ -249,6 +426,112 @@ func TestComplex(t *testing.T) {
 		{`2*c`, `luar.complex(4, 6)`},
 		// {`c^2`, `luar.complex(4, 6)`},
 		{`c / a`, `luar.complex(0.0625, 0.09375)`},
+		{`{c.re, c.im}`, `{2, 3}`},
+		{`c * c`, `luar.complex(-5, 12)`},
+	})
+
+	runGoTest(t, L, []goTestData{
+		{`c`, 2 + 3i, ""},
+		{`luar.complex(2, 3) * 2`, complex64(4 + 6i), ""},
+		{`{re=2, im=3}`, 2 + 3i, ""},
+		{`{2, 3}`, complex64(2 + 3i), ""},
+		{`{re=2}`, complex(2, 0), ""},
+		{`{re="foo"}`, 0i, "cannot convert"},
+	})
+}
+
+type (
+	settings map[string]int
+	dataset  map[string]int
+)
+
+func TestConversionPolicy(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	SetConversionPolicy(reflect.TypeOf(settings{}), ConvertToTable)
+	SetConversionPolicy(reflect.TypeOf(dataset{}), ConvertToProxy)
+	defer SetConversionPolicy(reflect.TypeOf(settings{}), ConvertDefault)
+	defer SetConversionPolicy(reflect.TypeOf(dataset{}), ConvertDefault)
+
+	Register(L, "", Map{
+		"settings": settings{"width": 80},
+		"ptr":      &settings{"width": 40},
+		"data":     dataset{"a": 1},
+		"other":    map[string]int{"a": 1},
+		"nested":   []settings{{"width": 60}},
+	})
+	GoToLua(L, dataset{"b": 2})
+	L.SetGlobal("copied")
+
+	runLuaTest(t, L, []luaTestData{
+		{`type(settings)`, `'table'`},
+		{`settings.width`, `80`},
+		{`type(ptr)`, `'table'`},
+		{`type(data)`, `'table<luar.dataset>'`},
+		{`type(other)`, `'table<map[string]int>'`},
+		{`type(copied)`, `'table<luar.dataset>'`},
+		{`copied.b`, `2`},
+		{`type(nested[1])`, `'table'`},
+	})
+}
+
+type rgb struct {
+	R, G, B uint8
+}
+
+func TestConverter(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	trgb := reflect.TypeOf(rgb{})
+	RegisterConverter(trgb, func(L *lua.State, v reflect.Value) {
+		c := v.Interface().(rgb)
+		L.PushString(fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B))
+	}, func(L *lua.State, idx int) (reflect.Value, error) {
+		var c rgb
+		_, err := fmt.Sscanf(L.ToString(idx), "#%02x%02x%02x", &c.R, &c.G, &c.B)
+		return reflect.ValueOf(c), err
+	})
+	defer RegisterConverter(trgb, nil, nil)
+
+	u, _ := url.Parse("https://example.com/path?q=1")
+	Register(L, "", Map{
+		"ip":      net.ParseIP("192.168.0.1"),
+		"u":       u,
+		"timeout": 90 * time.Second,
+		"red":     rgb{255, 0, 0},
+		"palette": []rgb{{0, 255, 0}},
+		"mix": func(a, b rgb) rgb {
+			return rgb{a.R | b.R, a.G | b.G, a.B | b.B}
+		},
+		"host": func(u *url.URL) string {
+			return u.Host
+		},
+		"isLoopback": func(ip net.IP) bool {
+			return ip.IsLoopback()
+		},
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`ip`, `"192.168.0.1"`},
+		{`u`, `"https://example.com/path?q=1"`},
+		{`timeout`, `"1m30s"`},
+		{`red`, `"#ff0000"`},
+		{`palette[1]`, `"#00ff00"`},
+		{`mix(red, "#0000ff")`, `"#ff00ff"`},
+		{`host("http://localhost:8080/")`, `"localhost:8080"`},
+		{`isLoopback("127.0.0.1")`, `true`},
+		{`pcall(isLoopback, "localhost")`, `false`},
+	})
+
+	runGoTest(t, L, []goTestData{
+		{`"10.0.0.1"`, net.ParseIP("10.0.0.1"), ""},
+		{`"500ms"`, 500 * time.Millisecond, ""},
+		{`1.5`, 1500 * time.Millisecond, ""},
+		{`"soon"`, time.Duration(0), "cannot convert"},
+		{`{"#010203"}`, []rgb{{1, 2, 3}}, ""},
+		{`nil`, rgb{}, ""},
 	})
 }
 
@@ -325,6 +608,19 @@ func TestCycleGoToLua(t *testing.T) {
 		}
 	}
 
+	{
+		l := &list{V: 17}
+		l.Next = l
+		GoToLua(L, l)
+		output_l := L.ToPointer(-1)
+		L.GetField(-1, "Next")
+		output_l_next := L.ToPointer(-1)
+		L.SetTop(0)
+		if output_l != output_l_next {
+			t.Error("address of self-referencing element differs")
+		}
+	}
+
 	{
 		l1 := &list{V: 17}
 		l2 := &list{V: 18}
@@ -501,6 +797,213 @@ func TestCycleLuaToGo(t *testing.T) {
 	}
 }
 
+func TestDeepEqual(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{
+		"alice": &person{"Alice", 16},
+		"bob":   &person{"Bob", 17},
+	})
+	GoToLuaProxy(L, []int{1, 2, 3})
+	L.SetGlobal("p1")
+	GoToLuaProxy(L, []int{1, 2, 3})
+	L.SetGlobal("p2")
+
+	runLuaTest(t, L, []luaTestData{
+		{`luar.deepequal(p1, p2)`, `true`},
+		{`p1 == p2`, `false`},
+		{`luar.deepequal(p1, {1, 2, 3})`, `true`},
+		{`luar.deepequal({1, 2, 3}, p1)`, `true`},
+		{`luar.deepequal(p1, {1, 2})`, `false`},
+		{`luar.deepequal(p1, {1, 2, "three"})`, `false`},
+		{`luar.deepequal(p1, luar.slice(0))`, `false`},
+		{`luar.deepequal(luar.map(), {})`, `true`},
+		{`luar.deepequal(alice, {Name = "Alice", Age = 16})`, `true`},
+		{`luar.deepequal(alice, {Name = "Alice", Age = 17})`, `false`},
+		{`luar.deepequal(alice, bob)`, `false`},
+		{`luar.deepequal({a = {1, 2}}, {a = {1, 2}})`, `true`},
+		{`luar.deepequal({a = {1, 2}}, {a = {1}})`, `false`},
+		{`luar.deepequal(17, 17)`, `true`},
+		{`luar.deepequal(nil, nil)`, `true`},
+		{`luar.deepequal(nil, false)`, `false`},
+	})
+}
+
+func TestDoStringContext(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := DoStringContext(L, ctx, `while true do end`)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	L.SetTop(0)
+
+	// Catching the error does not let the script carry on.
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	err = DoStringContext(L, ctx2, `while true do pcall(function() while true do end end) end`)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	L.SetTop(0)
+
+	// The hook is removed afterwards.
+	mustDoString(t, L, `n = 0; for i = 1, 10000 do n = n + 1 end`)
+	runLuaTest(t, L, []luaTestData{{`n`, `10000`}})
+
+	err = DoStringContext(L, context.Background(), `x = 17`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runLuaTest(t, L, []luaTestData{{`x`, `17`}})
+
+	err = DoStringContext(L, ctx, `x = 18`)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	runLuaTest(t, L, []luaTestData{{`x`, `17`}})
+}
+
+func TestDuration(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	type job struct {
+		Name     string
+		Interval time.Duration
+		Timeout  *time.Duration
+	}
+	var scheduled time.Duration
+	Register(L, "", Map{
+		"schedule": func(d time.Duration) time.Duration {
+			scheduled = d
+			return 2 * d
+		},
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`schedule("500ms")`, `"1s"`},
+		{`schedule(90)`, `"3m0s"`},
+		{`schedule(0.25)`, `"500ms"`},
+		{`pcall(schedule, "soon")`, `false`},
+		{`pcall(schedule, true)`, `false`},
+	})
+	if scheduled != 250*time.Millisecond {
+		t.Errorf("got %v, want 250ms", scheduled)
+	}
+
+	timeout := 2 * time.Minute
+	runGoTest(t, L, []goTestData{
+		{`"1h30m"`, 90 * time.Minute, ""},
+		{`"-1.5s"`, -1500 * time.Millisecond, ""},
+		{`3`, 3 * time.Second, ""},
+		{`{Name = "backup", Interval = "24h", Timeout = 120}`, job{Name: "backup", Interval: 24 * time.Hour, Timeout: &timeout}, ""},
+		{`{Interval = "fast"}`, job{}, ErrTableConv.Error()},
+	})
+
+	DurationUnit = time.Nanosecond
+	defer func() { DurationUnit = time.Second }()
+	runGoTest(t, L, []goTestData{
+		{`1500`, 1500 * time.Nanosecond, ""},
+		{`"1500ns"`, 1500 * time.Nanosecond, ""},
+	})
+	runLuaTest(t, L, []luaTestData{
+		{`schedule(1e9)`, `"2s"`},
+	})
+}
+
+func TestError(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	errBoom := errors.New("boom")
+	Register(L, "", Map{
+		"err": fmt.Errorf("wrapped: %w", errBoom),
+		"fail": func(fail bool) error {
+			if fail {
+				return errBoom
+			}
+			return nil
+		},
+		"check": func(err error) string {
+			if errors.Is(err, errBoom) {
+				return "boom"
+			}
+			return err.Error()
+		},
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`err:Error()`, `'wrapped: boom'`},
+		{`err.Error()`, `'wrapped: boom'`},
+		{`tostring(err)`, `'wrapped: boom'`},
+		{`"error: " .. err`, `'error: wrapped: boom'`},
+		{`fail(true):Error()`, `'boom'`},
+		{`fail(false)`, `nil`},
+		// Proxies are passed back as the original Go error.
+		{`check(err)`, `'boom'`},
+		// Strings are converted with errors.New.
+		{`check("foo")`, `'foo'`},
+	})
+}
+
+func TestErrorValues(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	errNotFound := errors.New("not found")
+	find := func(name string) (int, error) {
+		return 0, fmt.Errorf("find %q: %w", name, errNotFound)
+	}
+	RegisterWithErrors(L, "", Map{"find": find})
+
+	rethrow, err := LoadString(L, `local _, err = pcall(find, "foo"); error(err)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rethrow.Close()
+
+	// Errors are raised as messages by default.
+	err = rethrow.Call(nil)
+	if err == nil || errors.Is(err, errNotFound) {
+		t.Errorf("got %v, want a message-only error", err)
+	}
+	runLuaTest(t, L, []luaTestData{
+		{`type(select(2, pcall(find, "foo")))`, `"string"`},
+		{`luar.errorvalue(select(2, pcall(find, "foo")))`, `nil`},
+	})
+
+	RegisterWithErrors(L, "", Map{
+		"find": find,
+		"ok":   func() (int, error) { return 17, nil },
+		"none": func() error { return nil },
+	}, WithErrorValues())
+	runLuaTest(t, L, []luaTestData{
+		{`ok()`, `17`},
+		{`select('#', ok())`, `2`},
+		{`select('#', none())`, `1`},
+		{`pcall(find, "foo")`, `false`},
+		{`tostring(select(2, pcall(find, "foo")))`, `'find "foo": not found'`},
+		{`luar.errorvalue(select(2, pcall(find, "foo"))) ~= nil`, `true`},
+		{`luar.errorvalue("not found")`, `nil`},
+		{`luar.errorvalue(luar.slice())`, `nil`},
+	})
+
+	err = rethrow.Call(nil)
+	if !errors.Is(err, errNotFound) {
+		t.Errorf("got %v, want an error wrapping %v", err, errNotFound)
+	}
+	var luaErr *LuaError
+	if !errors.As(err, &luaErr) || luaErr.Error() != `find "foo": not found` {
+		t.Errorf("got %#v, want a *LuaError with the Go error message", err)
+	}
+	checkStack(t, L)
+}
+
 // See if Go values are not garbage collected.
 func TestGC(t *testing.T) {
 	L := Init()
@@ -569,6 +1072,27 @@ func TestGoToLuaFunction(t *testing.T) {
 		return v == nil
 	}
 
+	findPerson := func(name string) *person {
+		if name == "" {
+			return nil
+		}
+		return &person{Name: name}
+	}
+	noSlice := func() []int { return nil }
+
+	// Functions taking the state first can push results themselves.
+	minmax := func(L *lua.State, a, b int) int {
+		if a > b {
+			a, b = b, a
+		}
+		L.PushInteger(int64(a))
+		L.PushInteger(int64(b))
+		return 2
+	}
+	nargs := func(L *lua.State, prefix string) string {
+		return prefix + strconv.Itoa(L.GetTop())
+	}
+
 	// Trick here: we do not return a pointer to 'person' while GetName() is a
 	// method on pointer.
 	newDirectPerson := func(name string) person {
@@ -612,6 +1136,10 @@ func TestGoToLuaFunction(t *testing.T) {
 		"squares":         squares,
 		"IsNilInterface":  IsNilInterface,
 		"IsNilPointer":    IsNilPointer,
+		"findPerson":      findPerson,
+		"noSlice":         noSlice,
+		"minmax":          minmax,
+		"nargs":           nargs,
 		"newDirectPerson": newDirectPerson,
 		"pair2array":      pair2array,
 		"array2pair":      array2pair,
@@ -626,10 +1154,20 @@ func TestGoToLuaFunction(t *testing.T) {
 		{`{multiresult(42, 'foo')}`, `{42, 'foo'}`},
 		{`sum{1, 10, 100}`, `111`},      // Auto-convert table to slice.
 		{`sumv(1, 10, 100)`, `111`},     // Variadic call table to slice.
+		{`sumv{1, 10, 100}`, `111`},     // Variadic call with a single table.
+		{`sumv()`, `0`},                 // No variadic arguments.
+		{`sumv{}`, `0`},                 // Empty table.
 		{`squares{10, 20}['0']`, `100`}, // Proxy return value.
 		{`squares{10, 20}['1']`, `400`}, // Proxy return value.
+		{`#squares{10, 20}`, `2`},       // Proxy return value.
+		{`(function() local t = {}; for k, v in pairs(squares{10, 20}) do t[k] = v end; return t end)()`, `{['0'] = 100, ['1'] = 400}`},
 		{`IsNilInterface(nil)`, `true`},
 		{`IsNilPointer(nil)`, `true`},
+		{`findPerson("") == nil`, `true`},
+		{`findPerson("Alice").Name`, `"Alice"`},
+		{`noSlice() == nil`, `true`},
+		{`{minmax(3, 1)}`, `{1, 3}`},
+		{`nargs("n", "foo")`, `"n2"`},
 		{`type(newDirectPerson("Charly"))`, `"table<*luar.person>"`},
 		{`newDirectPerson("Charly").GetName()`, `"Charly"`},
 		{`{array2pair(pair2array(17, 18))}`, `{17, 18}`},
@@ -640,83 +1178,413 @@ func TestGoToLuaFunction(t *testing.T) {
 	if **a != 18 {
 		t.Errorf("got %v, want 18", **a)
 	}
+
+	// Functions returned by functions, or pushed directly, are callable.
+	adder := func(n int) func(int) int {
+		return func(x int) int { return x + n }
+	}
+	Register(L, "", Map{"adder": adder})
+	GoToLua(L, strings.ToUpper)
+	L.SetGlobal("upper")
+	runLuaTest(t, L, []luaTestData{
+		{`adder(10)(7)`, `17`},
+		{`(function() local add2 = adder(2); collectgarbage(); return add2(add2(1)) end)()`, `5`},
+		{`type(adder(1))`, `"function"`},
+		{`upper("foo")`, `"FOO"`},
+	})
 }
 
-func TestLuaObject(t *testing.T) {
+func TestGoToLuaFunctionOptions(t *testing.T) {
 	L := Init()
 	defer L.Close()
 
-	for _, name := range [][]interface{}{{""}, {"dummy"}, {"table.concat"}} {
-		a := NewLuaObjectFromName(L, name...)
-		a.Push()
-		if L.LTypename(-1) != "nil" {
-			t.Errorf(`got %q, want "nil"`, L.LTypename(-1))
-		}
-		L.Pop(1)
-	}
-	checkStack(t, L)
-
-	mustDoString(t, L, `t = {10, 20, 30}`)
-	a := NewLuaObjectFromName(L, "t")
-	defer a.Close()
-	err := a.Set(200, 2)
-	if err != nil {
-		t.Error(err)
-	}
-	checkStack(t, L)
-	res := 0
-	err = a.Get(&res, 2)
-	if res != 200 {
-		t.Errorf(`got %v, want 200`, res)
+	type fetchOptions struct {
+		Retries int
+		Timeout float64
+		Headers map[string]string
 	}
-	checkStack(t, L)
+	Register(L, "", Map{
+		"describe": func(name string, opts map[string]interface{}) string {
+			return fmt.Sprintf("%s %v %v", name, opts["verbose"], opts["depth"])
+		},
+		"fetch": func(url string, opts *fetchOptions) string {
+			if opts == nil {
+				return url + " (defaults)"
+			}
+			return fmt.Sprintf("%s %d %g %v", url, opts.Retries, opts.Timeout, opts.Headers)
+		},
+		"count": func(opts fetchOptions) int {
+			return opts.Retries
+		},
+		"join": func(sep string, parts ...string) string {
+			return strings.Join(parts, sep)
+		},
+	})
 
-	mustDoString(t, L, `t = {foo=17, bar=18, ["1"]=19, 20, ["qux.quuz"]=21, qux={quuz=22}}`)
-	a = NewLuaObjectFromName(L, "t")
-	err = a.Set(200, 1)
-	if err != nil {
-		t.Error(err)
-	}
-	err = a.Set(190, "1")
-	if err != nil {
-		t.Error(err)
-	}
-	checkStack(t, L)
+	runLuaTest(t, L, []luaTestData{
+		{`describe("x", {verbose = true, depth = 2})`, `"x true 2"`},
+		{`describe("x")`, `"x <nil> <nil>"`},
+		{`fetch("/", {Retries = 3, timeout = 1.5, Headers = {Accept = "*/*"}})`, `"/ 3 1.5 map[Accept:*/*]"`},
+		{`fetch("/")`, `"/ (defaults)"`},
+		{`fetch("/", nil)`, `"/ (defaults)"`},
+		{`count({Retries = 2})`, `2`},
+		{`count()`, `0`},
+		{`join(",", "a", "b")`, `"a,b"`},
+		{`pcall(describe)`, `false`},
+		{`pcall(fetch, "/", 17)`, `false`},
+	})
 
-	res = 0
-	err = a.Get(&res, 1)
-	if res != 200 {
-		t.Errorf(`got %v, want 200`, res)
-	}
-	err = a.Get(&res, "1")
-	if res != 190 {
-		t.Errorf(`got %v, want 190`, res)
-	}
-	err = a.Get(&res, "qux.quuz")
-	if res != 21 {
-		t.Errorf(`got %v, want 21`, res)
-	}
-	err = a.Get(&res, "qux", "quuz")
-	if res != 22 {
-		t.Errorf(`got %v, want 22`, res)
-	}
-	checkStack(t, L)
+	StrictStructs = true
+	defer func() { StrictStructs = false }()
+	runLuaTest(t, L, []luaTestData{
+		{`pcall(fetch, "/", {Retires = 3})`, `false`},
+	})
 }
 
-func TestLuaObjectMT(t *testing.T) {
+func TestGoToLuaFunctionPanic(t *testing.T) {
 	L := Init()
 	defer L.Close()
 
-	string__index := func(L *lua.State) int {
-		// Note: we skip error checking.
-		v, _ := valueOfProxy(L, 1)
-		k := L.ToInteger(2)
-		L.PushString(string(v.Index(k).Int()))
-		return 1
-	}
+	var m map[string]int
+	Register(L, "", Map{
+		"explicit": func() { panic("boom") },
+		"nilMap":   func() { m["foo"] = 17 },
+		"outOfRange": func(i int) int {
+			return []int{17}[i]
+		},
+		"value": func() { panic(17) },
+	})
 
-	string__newindex := func(L *lua.State) int {
-		// Note: we skip error checking.
+	runLuaTest(t, L, []luaTestData{
+		{`pcall(explicit)`, `false`},
+		{`select(2, pcall(explicit)):find("error boom", 1, true) ~= nil`, `true`},
+		{`pcall(nilMap)`, `false`},
+		{`select(2, pcall(nilMap)):find("nil map", 1, true) ~= nil`, `true`},
+		{`pcall(outOfRange, 3)`, `false`},
+		{`select(2, pcall(outOfRange, 3)):find("index out of range", 1, true) ~= nil`, `true`},
+		{`select(2, pcall(value)):find("error 17", 1, true) ~= nil`, `true`},
+		{`outOfRange(0)`, `17`},
+	})
+}
+
+func TestGoToLuaReadOnly(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	type server struct {
+		Host string
+		Port int
+	}
+	type config struct {
+		Name    string
+		Ports   []int
+		Server  server
+		Servers []server
+		Tags    map[string]string
+	}
+	cfg := config{
+		Name:    "prod",
+		Ports:   []int{80, 443},
+		Server:  server{Host: "localhost", Port: 8080},
+		Servers: []server{{Host: "a", Port: 1}},
+		Tags:    map[string]string{"env": "prod"},
+	}
+
+	GoToLuaReadOnly(L, &cfg)
+	L.SetGlobal("cfg")
+
+	runLuaTest(t, L, []luaTestData{
+		{`cfg.Name`, `"prod"`},
+		{`cfg.Ports[2]`, `443`},
+		{`cfg.Server.Port`, `8080`},
+		{`cfg.Servers[1].Host`, `"a"`},
+		{`cfg.Tags.env`, `"prod"`},
+		{`#cfg.Ports`, `2`},
+		{`pcall(function() cfg.Name = "dev" end)`, `false`},
+		{`select(2, pcall(function() cfg.Name = "dev" end)):find("read-only", 1, true) ~= nil`, `true`},
+		{`pcall(function() cfg.Ports[1] = 8080 end)`, `false`},
+		{`pcall(function() cfg.Server.Port = 0 end)`, `false`},
+		{`pcall(function() cfg.Servers[1].Host = "b" end)`, `false`},
+		{`pcall(function() cfg.Tags.env = "dev" end)`, `false`},
+		{`pcall(function() cfg.Ports:sub(1, 1)[1] = 0 end)`, `false`},
+		{`pcall(function() for _, s in ipairs(cfg.Servers) do s.Port = 0 end end)`, `false`},
+		{`pcall(luar.append, cfg.Ports, 8080)`, `false`},
+	})
+
+	if cfg.Name != "prod" || cfg.Ports[0] != 80 || cfg.Server.Port != 8080 || cfg.Servers[0].Host != "a" || cfg.Tags["env"] != "prod" || len(cfg.Ports) != 2 {
+		t.Errorf("read-only value was modified: %+v", cfg)
+	}
+
+	// Regular proxies are not affected.
+	GoToLuaProxy(L, &cfg)
+	L.SetGlobal("rw")
+	mustDoString(t, L, `rw.Ports[1] = 8080`)
+	if cfg.Ports[0] != 8080 {
+		t.Errorf("got %v, want 8080", cfg.Ports[0])
+	}
+}
+
+func TestIOMethods(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	f, err := ioutil.TempFile("", "luar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("first line\nsecond line\n")
+	f.Seek(0, 0)
+	defer f.Close()
+
+	var sb strings.Builder
+	Register(L, "", Map{
+		"f":  f,
+		"sb": &sb,
+		"p":  &person{Name: "foo"},
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`f:read(64):match("^[^\n]*")`, `"first line"`},
+		{`f.read(64)`, `nil`},
+		{`sb:write("hello, ")`, `7`},
+		{`sb.write("world")`, `5`},
+		{`p.read`, `nil`},
+		{`p.write`, `nil`},
+	})
+	if sb.String() != "hello, world" {
+		t.Errorf("got %q, want %q", sb.String(), "hello, world")
+	}
+
+	f.Seek(0, 0)
+	runLuaTest(t, L, []luaTestData{
+		{`f:read(5)`, `"first"`},
+		{`f:read(0)`, `""`},
+		// The buffer is not allocated upfront.
+		{`f:read(2^40)`, `" line\nsecond line\n"`},
+		{`f:read(2^40)`, `nil`},
+	})
+}
+
+type point struct {
+	X, Y int
+}
+
+func (p point) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"coords": [%d, %d], "label": null, "ok": true}`, p.X, p.Y)), nil
+}
+
+func TestInitWith(t *testing.T) {
+	L := InitWith(WithoutLibs("io", "os", "debug"))
+	defer L.Close()
+
+	runLuaTest(t, L, []luaTestData{
+		{`os`, `nil`},
+		{`io`, `nil`},
+		{`debug`, `nil`},
+		{`pcall(require, "os")`, `false`},
+		{`string.rep("a", 3)`, `"aaa"`},
+		{`math.floor(1.5)`, `1`},
+		{`luar.isnull(luar.null)`, `true`},
+		{`type(luar.slice(1))`, `"table<[]interface {}>"`},
+	})
+
+	// Errors are still reported without the debug library.
+	mustDoString(t, L, `function fail() error("boom") end`)
+	fail := NewLuaObjectFromName(L, "fail")
+	defer fail.Close()
+	err := fail.Call(nil)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("got error %v, want boom", err)
+	}
+
+	L2 := InitWith(NamespaceOnly())
+	defer L2.Close()
+	runLuaTest(t, L2, []luaTestData{
+		{`type(os.execute)`, `"function"`},
+		{`type(luar.slice(1))`, `"userdata"`},
+	})
+	checkStack(t, L)
+	checkStack(t, L2)
+}
+
+func TestJSONMarshalers(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	p := point{X: 1, Y: 2}
+
+	GoToLuaProxy(L, p)
+	L.SetGlobal("p")
+	runLuaTest(t, L, []luaTestData{
+		{`type(p)`, `'table<luar.point>'`},
+		{`p.X`, `1`},
+	})
+
+	JSONMarshalers = true
+	defer func() { JSONMarshalers = false }()
+
+	GoToLuaProxy(L, p)
+	L.SetGlobal("p")
+	GoToLua(L, []point{p, {X: 3, Y: 4}})
+	L.SetGlobal("ps")
+	runLuaTest(t, L, []luaTestData{
+		{`type(p)`, `'table'`},
+		{`p`, `{coords = {1, 2}, label = luar.null, ok = true}`},
+		{`ps[2].coords`, `{3, 4}`},
+	})
+}
+
+func TestLenientStrings(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	type config struct {
+		Host string
+		Port string
+	}
+	type label string
+
+	runGoTest(t, L, []goTestData{
+		{`8080`, "", "cannot convert"},
+		{`true`, "", "cannot convert"},
+		{`{Host = "localhost", Port = 8080}`, config{}, ErrTableConv.Error()},
+		{`"8080"`, "8080", ""},
+	})
+
+	LenientStrings = true
+	defer func() { LenientStrings = false }()
+	runGoTest(t, L, []goTestData{
+		{`8080`, "8080", ""},
+		{`8080.0`, "8080", ""},
+		{`-1.5`, "-1.5", ""},
+		{`true`, "true", ""},
+		{`false`, label("false"), ""},
+		{`{Host = "localhost", Port = 8080}`, config{Host: "localhost", Port: "8080"}, ""},
+		{`{1, "two", false}`, []string{"1", "two", "false"}, ""},
+		{`{}`, "", "cannot convert"},
+	})
+}
+
+func TestLoadString(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	double, err := LoadString(L, `local x = ...; return 2 * x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer double.Close()
+	for _, x := range []int{17, 21} {
+		got, err := double.CallInt(x)
+		if err != nil || got != 2*x {
+			t.Errorf("got %v (%v), want %v", got, err, 2*x)
+		}
+	}
+	checkStack(t, L)
+
+	_, err = LoadString(L, `return +`)
+	if err == nil {
+		t.Error("missing syntax error")
+	}
+	checkStack(t, L)
+
+	f, err := ioutil.TempFile("", "luar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`loaded = true; return 17`)
+	f.Close()
+	err = DoFile(L, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStack(t, L)
+	runLuaTest(t, L, []luaTestData{{`loaded`, `true`}})
+
+	err = DoFile(L, f.Name()+".missing")
+	if err == nil {
+		t.Error("missing error for nonexistent file")
+	}
+	checkStack(t, L)
+}
+
+func TestLuaObject(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	for _, name := range [][]interface{}{{""}, {"dummy"}, {"table.concat"}} {
+		a := NewLuaObjectFromName(L, name...)
+		a.Push()
+		if L.LTypename(-1) != "nil" {
+			t.Errorf(`got %q, want "nil"`, L.LTypename(-1))
+		}
+		L.Pop(1)
+	}
+	checkStack(t, L)
+
+	mustDoString(t, L, `t = {10, 20, 30}`)
+	a := NewLuaObjectFromName(L, "t")
+	defer a.Close()
+	err := a.Set(200, 2)
+	if err != nil {
+		t.Error(err)
+	}
+	checkStack(t, L)
+	res := 0
+	err = a.Get(&res, 2)
+	if res != 200 {
+		t.Errorf(`got %v, want 200`, res)
+	}
+	checkStack(t, L)
+
+	mustDoString(t, L, `t = {foo=17, bar=18, ["1"]=19, 20, ["qux.quuz"]=21, qux={quuz=22}}`)
+	a = NewLuaObjectFromName(L, "t")
+	err = a.Set(200, 1)
+	if err != nil {
+		t.Error(err)
+	}
+	err = a.Set(190, "1")
+	if err != nil {
+		t.Error(err)
+	}
+	checkStack(t, L)
+
+	res = 0
+	err = a.Get(&res, 1)
+	if res != 200 {
+		t.Errorf(`got %v, want 200`, res)
+	}
+	err = a.Get(&res, "1")
+	if res != 190 {
+		t.Errorf(`got %v, want 190`, res)
+	}
+	err = a.Get(&res, "qux.quuz")
+	if res != 21 {
+		t.Errorf(`got %v, want 21`, res)
+	}
+	err = a.Get(&res, "qux", "quuz")
+	if res != 22 {
+		t.Errorf(`got %v, want 22`, res)
+	}
+	checkStack(t, L)
+}
+
+func TestLuaObjectMT(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	string__index := func(L *lua.State) int {
+		// Note: we skip error checking.
+		v, _ := valueOfProxy(L, 1)
+		k := L.ToInteger(2)
+		L.PushString(string(v.Index(k).Int()))
+		return 1
+	}
+
+	string__newindex := func(L *lua.State) int {
+		// Note: we skip error checking.
 		v, _ := valueOfProxy(L, 1)
 		k := L.ToInteger(2)
 		val := rune(L.ToInteger(3))
@@ -751,6 +1619,66 @@ func TestLuaObjectMT(t *testing.T) {
 	checkStack(t, L)
 }
 
+func TestLuaObjectPath(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	mustDoString(t, L, `config = {server = {port = 8080, hosts = {"foo", "bar"}}, ["a.b"] = {c = 17}}`)
+	a := NewLuaObjectFromName(L, "config")
+	defer a.Close()
+
+	for _, test := range []struct {
+		path string
+		want interface{}
+	}{
+		{`server.port`, 8080},
+		{`server.hosts[2]`, "bar"},
+		{`["server"]["hosts"][1]`, "foo"},
+		{`['a.b'].c`, 17},
+	} {
+		got := reflect.New(reflect.TypeOf(test.want))
+		err := a.GetPath(got.Interface(), test.path)
+		if err != nil {
+			t.Errorf("%v: %v", test.path, err)
+		} else if got.Elem().Interface() != test.want {
+			t.Errorf("%v: got %v, want %v", test.path, got.Elem(), test.want)
+		}
+		checkStack(t, L)
+	}
+
+	for _, path := range []string{``, `.foo`, `foo.`, `foo..bar`, `foo[bar]`, `foo["bar]`, `foo[1]bar`} {
+		var res interface{}
+		if err := a.GetPath(&res, path); err == nil {
+			t.Errorf("missing error for path %q", path)
+		}
+	}
+
+	var res interface{}
+	if err := a.GetPath(&res, "client.port"); err == nil {
+		t.Error("missing error for missing intermediate table")
+	}
+	checkStack(t, L)
+
+	err := a.SetPath(9090, "server.port", false)
+	if err != nil {
+		t.Error(err)
+	}
+	err = a.SetPath(17, "client.port", false)
+	if err == nil {
+		t.Error("missing error for missing intermediate table")
+	}
+	err = a.SetPath(17, "client.options.port", true)
+	if err != nil {
+		t.Error(err)
+	}
+	checkStack(t, L)
+
+	runLuaTest(t, L, []luaTestData{
+		{`config.server.port`, `9090`},
+		{`config.client`, `{options = {port = 17}}`},
+	})
+}
+
 func TestLuaObjectCall(t *testing.T) {
 	L := Init()
 	defer L.Close()
@@ -841,77 +1769,274 @@ end
 	}
 }
 
-func TestLuaObjectCallMT(t *testing.T) {
+func TestLuaObjectCallErr(t *testing.T) {
 	L := Init()
 	defer L.Close()
 
-	const code = `
-a = {17}
-setmetatable(a, { __call = function(arg) a[1] = a[1] + arg end })
-`
+	mustDoString(t, L, `
+function open(name)
+	if name == "" then
+		return nil, "empty name"
+	end
+	return name, nil
+end
+`)
+	open := NewLuaObjectFromName(L, "open")
 
-	mustDoString(t, L, code)
-	a := NewLuaObjectFromName(L, "a")
-	err := a.Call(nil, 2)
+	res, err := open.CallErr("foo")
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	res := 0
-	a.Get(&res, 1)
-	if res != 19 {
-		t.Fatalf("got %q, want 19", res)
+	want := []interface{}{"foo"}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("got %v, want %v", res, want)
 	}
 	checkStack(t, L)
 
-	err = a.Call(nil)
-	wantErr := "[string \"...\"]:3: attempt to perform arithmetic on local 'arg' (a nil value)"
-	if err == nil || err.Error() != wantErr {
-		t.Fatalf("got error %q, want %q", err, wantErr)
+	res, err = open.CallErr("")
+	if err == nil || err.Error() != "empty name" {
+		t.Errorf("got error %v, want %q", err, "empty name")
+	}
+	if res != nil {
+		t.Errorf("got %v, want no results", res)
 	}
 	checkStack(t, L)
 }
 
-func TestLuaObjectIter(t *testing.T) {
+func TestLuaObjectCallInto(t *testing.T) {
 	L := Init()
 	defer L.Close()
 
-	mustDoString(t, L, `a = {foo=10, bar=20}`)
+	mustDoString(t, L, `function split(s) return #s, s:upper() end`)
+	split := NewLuaObjectFromName(L, "split")
 
-	a := NewLuaObjectFromName(L, "a")
-	iter, err := a.Iter()
+	var n int
+	var s string
+	err := CallInto(split, []interface{}{"foo"}, &n, &s)
 	if err != nil {
 		t.Fatal(err)
 	}
-	checkStack(t, L)
-
-	keys := []string{}
-	values := map[string]float64{}
-	for key, value := "", 0.0; iter.Next(&key, &value); {
-		keys = append(keys, key)
-		values[key] = value
-	}
-	sort.Strings(keys)
-
-	wantKeys := []string{"bar", "foo"}
-	if !reflect.DeepEqual(keys, wantKeys) {
-		t.Errorf("got %q, want %q", keys, wantKeys)
+	if n != 3 || s != "FOO" {
+		t.Errorf("got %v, %q, want 3, %q", n, s, "FOO")
 	}
+	checkStack(t, L)
 
-	wantValues := map[string]float64{"foo": 10, "bar": 20}
-	if !reflect.DeepEqual(values, wantValues) {
-		t.Errorf("got %q, want %q", keys, wantValues)
+	err = CallInto(split, []interface{}{"foo"}, &s, &n)
+	if err == nil {
+		t.Error("missing conversion error")
 	}
-
 	checkStack(t, L)
 }
 
-func TestLuaObjectIterMT(t *testing.T) {
+func TestLuaObjectCallTyped(t *testing.T) {
 	L := Init()
 	defer L.Close()
 
-	Register(L, "", Map{"a": map[string]float64{"foo": 10, "bar": 20}})
-
+	mustDoString(t, L, `
+function name() return "foo" end
+function count(n) return n + 1 end
+function even(n) return n % 2 == 0 end
+function none() end
+`)
+	name := NewLuaObjectFromName(L, "name")
+	count := NewLuaObjectFromName(L, "count")
+	even := NewLuaObjectFromName(L, "even")
+	none := NewLuaObjectFromName(L, "none")
+
+	s, err := name.CallString()
+	if err != nil || s != "foo" {
+		t.Errorf("got %q (%v), want %q", s, err, "foo")
+	}
+	i, err := count.CallInt(16)
+	if err != nil || i != 17 {
+		t.Errorf("got %v (%v), want 17", i, err)
+	}
+	b, err := even.CallBool(16)
+	if err != nil || !b {
+		t.Errorf("got %v (%v), want true", b, err)
+	}
+	var f float64
+	err = count.Call1(&f, 0.5)
+	if err != nil || f != 1.5 {
+		t.Errorf("got %v (%v), want 1.5", f, err)
+	}
+	checkStack(t, L)
+
+	_, err = none.CallInt()
+	if err != ErrLuaObjectNoResult {
+		t.Errorf("got error %v, want %v", err, ErrLuaObjectNoResult)
+	}
+	_, err = name.CallInt()
+	if err == nil {
+		t.Error("missing conversion error")
+	}
+	_, err = count.CallString(16)
+	if err == nil {
+		t.Error("missing conversion error")
+	}
+	_, err = count.CallInt("foo")
+	if err == nil {
+		t.Error("missing runtime error")
+	}
+	checkStack(t, L)
+}
+
+func TestLuaObjectCallMT(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	const code = `
+a = {17}
+setmetatable(a, { __call = function(arg) a[1] = a[1] + arg end })
+`
+
+	mustDoString(t, L, code)
+	a := NewLuaObjectFromName(L, "a")
+	err := a.Call(nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := 0
+	a.Get(&res, 1)
+	if res != 19 {
+		t.Fatalf("got %q, want 19", res)
+	}
+	checkStack(t, L)
+
+	err = a.Call(nil)
+	wantErr := "[string \"...\"]:3: attempt to perform arithmetic on local 'arg' (a nil value)"
+	if err == nil || err.Error() != wantErr {
+		t.Fatalf("got error %q, want %q", err, wantErr)
+	}
+	checkStack(t, L)
+}
+
+func TestLuaObjectCallTraceback(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	const code = `
+function checkAge(age)
+	if age < 0 then
+		error("negative age")
+	end
+	return age
+end
+function validate(age)
+	return checkAge(age)
+end
+`
+
+	mustDoString(t, L, code)
+	validate := NewLuaObjectFromName(L, "validate")
+	defer validate.Close()
+
+	err := validate.Call(nil, -1)
+	lerr, ok := err.(*LuaError)
+	if !ok {
+		t.Fatalf("got error %#v, want a *LuaError", err)
+	}
+	wantMsg := "[string \"...\"]:4: negative age"
+	if lerr.Message() != wantMsg || lerr.Error() != wantMsg {
+		t.Errorf("got message %q, want %q", lerr.Message(), wantMsg)
+	}
+	if !strings.HasPrefix(lerr.Traceback(), "stack traceback:") || !strings.Contains(lerr.Traceback(), "checkAge") {
+		t.Errorf("traceback does not mention checkAge:\n%s", lerr.Traceback())
+	}
+	checkStack(t, L)
+
+	res, err := validate.CallInt(17)
+	if err != nil || res != 17 {
+		t.Errorf("got %v, %v, want 17", res, err)
+	}
+	checkStack(t, L)
+}
+
+func TestLuaObjectEach(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	mustDoString(t, L, `a = {"foo", "bar", baz=true, qux={17}}`)
+	a := NewLuaObjectFromName(L, "a")
+	defer a.Close()
+
+	got := map[interface{}]interface{}{}
+	err := a.Each(func(key, value interface{}) bool {
+		got[key] = value
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[interface{}]interface{}{
+		1.0:   "foo",
+		2.0:   "bar",
+		"baz": true,
+		"qux": []interface{}{17.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	n := 0
+	err = a.Each(func(key, value interface{}) bool {
+		n++
+		return false
+	})
+	if err != nil || n != 1 {
+		t.Errorf("got %v calls (error %v), want 1", n, err)
+	}
+
+	mustDoString(t, L, `b = 17`)
+	b := NewLuaObjectFromName(L, "b")
+	defer b.Close()
+	if err := b.Each(func(key, value interface{}) bool { return true }); err != ErrLuaObjectIndexable {
+		t.Errorf("got error %v, want %v", err, ErrLuaObjectIndexable)
+	}
+	checkStack(t, L)
+}
+
+func TestLuaObjectIter(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	mustDoString(t, L, `a = {foo=10, bar=20}`)
+
+	a := NewLuaObjectFromName(L, "a")
+	iter, err := a.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStack(t, L)
+
+	keys := []string{}
+	values := map[string]float64{}
+	for key, value := "", 0.0; iter.Next(&key, &value); {
+		keys = append(keys, key)
+		values[key] = value
+	}
+	sort.Strings(keys)
+
+	wantKeys := []string{"bar", "foo"}
+	if !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("got %q, want %q", keys, wantKeys)
+	}
+
+	wantValues := map[string]float64{"foo": 10, "bar": 20}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("got %q, want %q", keys, wantValues)
+	}
+
+	checkStack(t, L)
+}
+
+func TestLuaObjectIterMT(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{"a": map[string]float64{"foo": 10, "bar": 20}})
+
 	a := NewLuaObjectFromName(L, "a")
 
 	iter, err := a.Iter()
@@ -987,6 +2112,121 @@ end
 	if *result != 17 {
 		t.Errorf("got %v, want 17", *result)
 	}
+
+	// Lua functions as Go function parameters.
+	sortInts := func(a []int, less func(int, int) bool) []int {
+		sort.Slice(a, func(i, j int) bool { return less(a[i], a[j]) })
+		return a
+	}
+	apply := func(f func(...int) (int, error), args ...int) (int, string) {
+		res, err := f(args...)
+		if err != nil {
+			return res, err.Error()
+		}
+		return res, ""
+	}
+	Register(L, "", Map{"sortInts": sortInts, "apply": apply})
+	runLuaTest(t, L, []luaTestData{
+		{`luar.unproxify(sortInts({3, 1, 2}, function(a, b) return a > b end))`, `{3, 2, 1}`},
+		{`{apply(function(a, b) return a + b end, 17, 18)}`, `{35, ""}`},
+		{`{apply(function() return 0, "foo" end)}`, `{0, "foo"}`},
+		{`select(2, apply(function() error("bar") end)):find("bar", 1, true) ~= nil`, `true`},
+	})
+
+	// Without an error result, errors are raised in Lua.
+	var f func() int
+	mustDoString(t, L, `return function() error("baz") end`)
+	err = LuaToGo(L, -1, &f)
+	L.Pop(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("missing panic from failing Lua function")
+			}
+		}()
+		f()
+	}()
+	checkStack(t, L)
+
+	// The Lua functions are released once the Go functions are collected, so
+	// the registry does not grow with the calls.
+	sortMany := func() int {
+		mustDoString(t, L, `for i = 1, 100 do sortInts({2, 1}, function(a, b) return a < b end) end`)
+		for i := 0; i < 5; i++ {
+			runtime.GC()
+			time.Sleep(10 * time.Millisecond)
+		}
+		// The references are released on the next call.
+		mustDoString(t, L, `sortInts({}, function() end)`)
+		return int(L.ObjLen(lua.LUA_REGISTRYINDEX))
+	}
+	n := sortMany()
+	if m := sortMany(); m > n+10 {
+		t.Errorf("registry grew from %v to %v entries", n, m)
+	}
+	checkStack(t, L)
+}
+
+func TestLuaToGoInterface(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{"alice": &person{"Alice", 16}})
+	mustDoString(t, L, `return {
+	name = "server",
+	ports = {80, 443},
+	tls = {enabled = true, hosts = {"a.example", "b.example"}},
+	routes = {{path = "/", weight = 1}, {path = "/api"}},
+	empty = {},
+	admin = alice,
+}`)
+
+	var got interface{}
+	if err := LuaToGo(L, -1, &got); err != nil {
+		t.Fatal(err)
+	}
+	L.Pop(1)
+
+	// Sequences become slices, other tables become maps, recursively.
+	want := map[string]interface{}{
+		"name":  "server",
+		"ports": []interface{}{80.0, 443.0},
+		"tls": map[string]interface{}{
+			"enabled": true,
+			"hosts":   []interface{}{"a.example", "b.example"},
+		},
+		"routes": []interface{}{
+			map[string]interface{}{"path": "/", "weight": 1.0},
+			map[string]interface{}{"path": "/api"},
+		},
+		"empty": []interface{}{},
+		"admin": &person{"Alice", 16},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	// The result holds no proxy and can be encoded.
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantJSON = `{"admin":{"Name":"Alice","Age":16},"empty":[],"name":"server","ports":[80,443],"routes":[{"path":"/","weight":1},{"path":"/api"}],"tls":{"enabled":true,"hosts":["a.example","b.example"]}}`
+	if string(b) != wantJSON {
+		t.Errorf("got %s, want %s", b, wantJSON)
+	}
+	checkStack(t, L)
+
+	PreferIntForWholeNumbers = true
+	defer func() { PreferIntForWholeNumbers = false }()
+	runGoTest(t, L, []goTestData{
+		{`{17, 1.5, -3, 2^53, 1e300}`, []interface{}{int64(17), 1.5, int64(-3), int64(1 << 53), 1e300}, ""},
+		{`{a = 2.0, b = 0.25}`, map[string]interface{}{"a": int64(2), "b": 0.25}, ""},
+		{`17`, 17.0, ""},
+	})
 }
 
 func TestLuaToGoPointers(t *testing.T) {
@@ -1041,8 +2281,132 @@ func TestLuaToGoPointers(t *testing.T) {
 	foo := func(i *int) int {
 		return *i
 	}
-	Register(L, "", Map{"foo": foo})
-	runLuaTest(t, L, []luaTestData{{`foo(17)`, `17`}})
+	Register(L, "", Map{
+		"foo":   foo,
+		"isNil": func(p *int) bool { return p == nil },
+	})
+	runLuaTest(t, L, []luaTestData{
+		{`foo(17)`, `17`},
+		{`isNil(nil)`, `true`},
+		{`isNil(17)`, `false`},
+		// Only an explicit nil nullifies the pointer.
+		{`pcall(isNil)`, `false`},
+	})
+
+	// Intermediate pointers are allocated, nil pointers are reset.
+	ipp = new(*int)
+	mustDoString(t, L, `return 18`)
+	err = LuaToGo(L, -1, ipp)
+	printError("")
+	if *ipp == nil || **ipp != 18 {
+		t.Errorf("got %v, want pointer to 18", *ipp)
+	}
+	L.Pop(1)
+	L.PushNil()
+	err = LuaToGo(L, -1, ipp)
+	printError("")
+	if *ipp != nil {
+		t.Errorf("got %v, want nil pointer", *ipp)
+	}
+	L.Pop(1)
+
+	// Optional fields.
+	type server struct {
+		Host *string
+		Port *int
+		Next **server
+	}
+	runGoTest(t, L, []goTestData{
+		{`{Port = 8080}`, server{Port: intPtr(8080)}, ""},
+		{`{}`, server{}, ""},
+		{`{Port = luar.null}`, server{}, ""},
+		{`{Port = 0}`, server{Port: intPtr(0)}, ""},
+		{`{1, nil, 2}`, []*int{intPtr(1), nil, intPtr(2)}, ""},
+	})
+
+	var srv server
+	mustDoString(t, L, `return {Host = "a", Next = {Port = 1}}`)
+	err = LuaToGo(L, -1, &srv)
+	printError("")
+	L.Pop(1)
+	if srv.Host == nil || *srv.Host != "a" || srv.Port != nil || srv.Next == nil || *srv.Next == nil || *(*srv.Next).Port != 1 {
+		t.Errorf("got %+v", srv)
+	}
+	checkStack(t, L)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestLuaToGoStream(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	const n = 1000000
+	mustDoString(t, L, fmt.Sprintf(`t = {}; for i = 1, %d do t[i] = i end`, n))
+	L.GetGlobal("t")
+	sum := 0
+	count := 0
+	err := LuaToGoStream(L, -1, reflect.TypeOf(0), func(v reflect.Value) error {
+		sum += int(v.Int())
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != n || sum != n*(n+1)/2 {
+		t.Errorf("got %v elements summing to %v, want %v summing to %v", count, sum, n, n*(n+1)/2)
+	}
+	L.Pop(1)
+	checkStack(t, L)
+
+	type row struct {
+		Name  string
+		Score float64
+	}
+	mustDoString(t, L, `return {{Name = "a", Score = 1.5}, {Name = "b", Score = 2}}`)
+	var rows []row
+	err = LuaToGoStream(L, -1, reflect.TypeOf(row{}), func(v reflect.Value) error {
+		rows = append(rows, v.Interface().(row))
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if want := []row{{"a", 1.5}, {"b", 2}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+	L.Pop(1)
+
+	// Errors stop the walk.
+	stop := errors.New("stop")
+	mustDoString(t, L, `return {1, 2, "three", 4}`)
+	count = 0
+	err = LuaToGoStream(L, -1, reflect.TypeOf(0), func(v reflect.Value) error {
+		count++
+		return nil
+	})
+	if _, ok := err.(ConvError); !ok || count != 2 {
+		t.Errorf("got error %v after %v elements, want ConvError after 2", err, count)
+	}
+	count = 0
+	err = LuaToGoStream(L, -1, reflect.TypeOf(0), func(v reflect.Value) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("got error %v after %v elements, want %v after 1", err, count, stop)
+	}
+	L.Pop(1)
+
+	L.PushInteger(17)
+	if err = LuaToGoStream(L, -1, reflect.TypeOf(0), nil); err == nil {
+		t.Error("missing error for non-table")
+	}
+	L.Pop(1)
+	checkStack(t, L)
 }
 
 type myMap map[string]int
@@ -1057,6 +2421,43 @@ func (m *myIntMap) Foo() int {
 	return len(*m)
 }
 
+// LuaToGo fills typed variables directly, no type assertion needed.
+func TestLuaToGoTyped(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	mustDoString(t, L, `return 17, {"foo", "bar"}, {Name="Alice", Age=16}`)
+
+	var i int
+	if err := LuaToGo(L, -3, &i); err != nil || i != 17 {
+		t.Errorf("got %v (error %v), want 17", i, err)
+	}
+
+	var s []string
+	if err := LuaToGo(L, -2, &s); err != nil || !reflect.DeepEqual(s, []string{"foo", "bar"}) {
+		t.Errorf("got %#v (error %v), want [foo bar]", s, err)
+	}
+
+	var p person
+	if err := LuaToGo(L, -1, &p); err != nil || p != (person{Name: "Alice", Age: 16}) {
+		t.Errorf("got %#v (error %v), want Alice, 16", p, err)
+	}
+
+	// Wrong destinations are reported as errors.
+	if err := LuaToGo(L, -1, p); err == nil {
+		t.Error("missing error when passing a non-pointer")
+	}
+	if err := LuaToGo(L, -1, (*person)(nil)); err == nil {
+		t.Error("missing error when passing a nil pointer")
+	}
+	if err := LuaToGo(L, -1, &i); err == nil {
+		t.Error("missing error when converting a table to an int")
+	}
+
+	L.Pop(3)
+	checkStack(t, L)
+}
+
 func TestMap(t *testing.T) {
 	L := Init()
 	defer L.Close()
@@ -1132,6 +2533,22 @@ func TestMap(t *testing.T) {
 	if !reflect.DeepEqual(i, want3) {
 		t.Errorf("got %#v, want %#v from Lua->Go conversion of `%v`", i, want3, input)
 	}
+	L.Pop(1)
+
+	// Keys other than booleans, numbers and strings can be stringified.
+	StringMapKeys = true
+	defer func() { StringMapKeys = false }()
+	GoToLua(L, map[int]int{1: 10, 2: 20})
+	L.SetGlobal("ints")
+	GoToLua(L, map[newStruct]string{{1, 2}: "foo", {3, 4}: "bar"})
+	L.SetGlobal("structs")
+	GoToLua(L, map[interface{}]int{color(1): 17, "baz": 18})
+	L.SetGlobal("stringers")
+	runLuaTest(t, L, []luaTestData{
+		{`ints`, `{10, 20}`},
+		{`structs`, `{["{1 2}"]="foo", ["{3 4}"]="bar"}`},
+		{`stringers`, `{green=17, baz=18}`},
+	})
 }
 
 type hasName interface {
@@ -1142,6 +2559,10 @@ func (p *person) GetName() string {
 	return p.Name
 }
 
+func (p *person) SetName(name string) {
+	p.Name = name
+}
+
 func newPerson(name string, age int) *person {
 	return &person{name, age}
 }
@@ -1154,13 +2575,100 @@ func getName(o hasName) string {
 	return o.GetName()
 }
 
-func TestProxy(t *testing.T) {
+func TestMethodValue(t *testing.T) {
 	L := Init()
 	defer L.Close()
 
-	Register(L, "", Map{"a": myIntA(17)})
+	Register(L, "", Map{
+		"newPerson": newPerson,
+	})
 
-	runGoTest(t, L, []goTestData{
+	mustDoString(t, L, `
+do
+	local p = newPerson("Alice", 17)
+	getName = p.GetName
+	setName = p.SetName
+end
+collectgarbage()
+collectgarbage()
+setName("Bob")
+`)
+
+	runLuaTest(t, L, []luaTestData{
+		{`getName()`, `'Bob'`},
+		{`newPerson("Charly", 18).GetName()`, `'Charly'`},
+	})
+	checkStack(t, L)
+}
+
+func TestNamespace(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "gons", Map{
+		"foo":   func() {},
+		"bar":   strings.ToUpper,
+		"baz":   17,
+		"Hello": "world",
+	})
+	mustDoString(t, L, `gons[1] = "ignored"`)
+
+	got, err := Namespace(L, "gons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Hello", "bar", "baz", "foo"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got, err = Namespace(L, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := sort.SearchStrings(got, "gons"); i == len(got) || got[i] != "gons" {
+		t.Errorf("global namespace %q misses 'gons'", got)
+	}
+
+	if _, err := Namespace(L, "nope"); err == nil {
+		t.Error("missing error for undefined namespace")
+	}
+	checkStack(t, L)
+}
+
+func TestNull(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	GoToLua(L, []interface{}{nil, 17})
+	L.SetGlobal("s")
+	GoToLua(L, map[string]*person{"a": nil})
+	L.SetGlobal("m")
+	runLuaTest(t, L, []luaTestData{
+		{`rawequal(s[1], luar.null)`, `true`},
+		{`rawequal(m.a, luar.null)`, `true`},
+		{`rawequal(luar.unproxify(luar.slice(1))[1], luar.null)`, `true`},
+		{`luar.isnull(s[1])`, `true`},
+		{`luar.isnull(nil)`, `true`},
+		{`luar.isnull(s[2])`, `false`},
+		{`luar.isnull(false)`, `false`},
+	})
+
+	runGoTest(t, L, []goTestData{
+		{`s`, []interface{}{nil, 17.0}, ""},
+		{`{luar.null}`, []*person{nil}, ""},
+		{`m`, map[string]*person{"a": nil}, ""},
+		{`luar.null`, (*person)(nil), ""},
+	})
+}
+
+func TestProxy(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{"a": myIntA(17)})
+
+	runGoTest(t, L, []goTestData{
 		{`a`, myIntA(17), ""},
 		{`a`, myIntB(17), ""},
 		{`a`, 17, ""},
@@ -1280,6 +2788,80 @@ func TestProxyArray(t *testing.T) {
 	}
 }
 
+func TestProxyIdentity(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	alice := &person{Name: "Alice", Age: 16}
+	bob := &person{Name: "Bob", Age: 17}
+
+	GoToLuaProxy(L, alice)
+	L.SetGlobal("a1")
+	GoToLuaProxy(L, alice)
+	L.SetGlobal("a2")
+	GoToLuaProxy(L, bob)
+	L.SetGlobal("b")
+	GoToLuaProxy(L, *alice)
+	L.SetGlobal("copy")
+	GoToLuaReadOnly(L, alice)
+	L.SetGlobal("ro")
+	Register(L, "", Map{
+		"same": func() *person { return alice },
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`rawequal(a1, a2)`, `true`},
+		{`rawequal(a1, same())`, `true`},
+		{`rawequal(a1, b)`, `false`},
+		{`rawequal(a1, copy)`, `false`},
+		{`rawequal(a1, ro)`, `false`},
+		{`(function() local t = {[a1] = 1}; return t[a2] end)()`, `1`},
+	})
+
+	// The cache does not keep the proxies alive.
+	mustDoString(t, L, `a1, a2, ro = nil, nil, nil; collectgarbage(); collectgarbage()`)
+	GoToLuaProxy(L, alice)
+	L.SetGlobal("a3")
+	runLuaTest(t, L, []luaTestData{
+		{`a3.Name`, `"Alice"`},
+		{`rawequal(a3, same())`, `true`},
+	})
+}
+
+// Interface values keep their dynamic type across Lua.
+func TestProxyInterface(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	p := &person{Name: "Alice", Age: 16}
+	var got hasName
+	Register(L, "", Map{
+		"find":  func() hasName { return p },
+		"store": func(o hasName) { got = o },
+	})
+
+	mustDoString(t, L, `local o = find(); store(o)`)
+	if got != hasName(p) {
+		t.Errorf("got %#v, want the original %p", got, p)
+	}
+	runLuaTest(t, L, []luaTestData{
+		{`type(find())`, `'table<*luar.person>'`},
+		{`find().GetName()`, `'Alice'`},
+		{`find() == find()`, `true`},
+	})
+
+	mustDoString(t, L, `return find()`)
+	var i interface{}
+	err := LuaToGo(L, -1, &i)
+	if err != nil {
+		t.Error(err)
+	}
+	if i != interface{}(p) {
+		t.Errorf("got %#v, want the original %p", i, p)
+	}
+	L.Pop(1)
+}
+
 type myIntA int
 
 func newIntA(i int) myIntA {
@@ -1296,6 +2878,18 @@ func NewIntB(i int) myIntB {
 	return myIntB(i)
 }
 
+type myUintA uint
+
+func newUintA(i uint) myUintA {
+	return myUintA(i)
+}
+
+type myFloatA float64
+
+func newFloatA(f float64) myFloatA {
+	return myFloatA(f)
+}
+
 type myStringA string
 
 func newStringA(s string) myStringA {
@@ -1322,15 +2916,21 @@ func TestProxyScalars(t *testing.T) {
 
 	i := myIntA(3)
 	j := myIntB(17)
+	u := myUintA(8)
+	f := myFloatA(2.5)
 	s1 := myStringA("foo")
 	s2 := myStringB("bar")
 
 	Register(L, "", Map{
 		"i":          i,
 		"j":          j,
+		"u":          u,
+		"f":          f,
 		"s1":         s1,
 		"s2":         s2,
 		"newIntA":    newIntA,
+		"newUintA":   newUintA,
+		"newFloatA":  newFloatA,
 		"newStringA": newStringA,
 	})
 
@@ -1368,13 +2968,34 @@ func TestProxyScalars(t *testing.T) {
 		{`2%i`, `newIntA(2)`},
 		{`3==i`, `false`},
 		{`3~=i`, `true`},
-		// Proxy A & proxy B
-		{`i+j`, `20`},
-		{`i<j`, `true`},
+		// Proxy A & proxy B: distinct types do not mix.
+		{`pcall(function() return i+j end)`, `false`},
+		{`pcall(function() return i<j end)`, `false`},
+		{`select(2, pcall(function() return i*j end)):find("mismatched types", 1, true) ~= nil`, `true`},
 		// Proxy B & proxy A
-		{`j+i`, `20`},
-		{`j<i`, `false`},
+		{`pcall(function() return j+i end)`, `false`},
+		{`pcall(function() return j<i end)`, `false`},
+		{`pcall(function() return i+f end)`, `false`},
 		{`i.FooIntA()`, `"FooIntA"`},
+		// Unsigned number proxy.
+		{`u+u`, `newUintA(16)`},
+		{`u-newUintA(5)`, `newUintA(3)`},
+		{`u*u`, `newUintA(64)`},
+		{`u/newUintA(3)`, `newUintA(2)`},
+		{`u%newUintA(3)`, `newUintA(2)`},
+		{`newUintA(5) < u`, `true`},
+		{`newUintA(5) <= u`, `true`},
+		{`u <= newUintA(5)`, `false`},
+		// Float number proxy.
+		{`f+f`, `newFloatA(5)`},
+		{`f-newFloatA(0.5)`, `newFloatA(2)`},
+		{`f*f`, `newFloatA(6.25)`},
+		{`f/newFloatA(0.5)`, `newFloatA(5)`},
+		{`f%newFloatA(1)`, `newFloatA(0.5)`},
+		{`-f`, `newFloatA(-2.5)`},
+		{`f < newFloatA(3)`, `true`},
+		{`f <= newFloatA(2.5)`, `true`},
+		{`newFloatA(3) <= f`, `false`},
 		// Strings.
 		{`s1`, `newStringA("foo")`},
 		// {`s1`, `"foo"`}, // Not equal.
@@ -1418,6 +3039,9 @@ func TestProxyMap(t *testing.T) {
 		{`c[2]`, `20`},
 		{`c.Foo()`, `2`},
 		{`c.bar`, `nil`},
+		{`#a`, `2`},
+		{`#m`, `5`},
+		{`#luar.map()`, `0`},
 	})
 
 	mustDoString(t, L, `t = {}
@@ -1441,14 +3065,270 @@ p[k] = v
 end
 `)
 	runLuaTest(t, L, []luaTestData{{`p`, `{foo="bar", baz="qux"}`}})
+
+	mustDoString(t, L, `
+keys = luar.unproxify(luar.keys(a))
+table.sort(keys)
+values = luar.unproxify(luar.values(a))
+table.sort(values)
+`)
+	runLuaTest(t, L, []luaTestData{
+		{`keys`, `{"bar", "foo"}`},
+		{`values`, `{17, 170}`},
+		{`#luar.keys(luar.map())`, `0`},
+		{`pcall(luar.keys, {})`, `false`},
+	})
+
+	mustDoString(t, L, `
+sorted = {}
+for k, v in luar.pairsSorted(m) do
+	sorted[#sorted+1] = k
+end
+`)
+	runLuaTest(t, L, []luaTestData{
+		{`(function() for k, v in luar.pairsSorted(a) do return k, v end end)()`, `"bar"`},
+		{`sorted`, `{-1, 0, 1, 2, "3"}`},
+	})
+
+	// Deleting entries while iterating.
+	d := map[string]int{"foo": 1, "bar": 2, "baz": 3}
+	clear := func() {
+		for k := range d {
+			delete(d, k)
+		}
+	}
+	Register(L, "", Map{"d": d, "clear": clear})
+	mustDoString(t, L, `
+count = 0
+for k, v in pairs(d) do
+count = count + 1
+clear()
+end
+`)
+	runLuaTest(t, L, []luaTestData{{`count`, `1`}})
 }
 
 type mySlice []int
 
+func TestProxyMapGet(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	counts := map[string]int{"zero": 0, "one": 1}
+	ptrs := map[int]*person{1: {"Alice", 16}, 2: nil}
+	GoToLuaProxy(L, counts)
+	L.SetGlobal("counts")
+	GoToLuaProxy(L, ptrs)
+	L.SetGlobal("ptrs")
+
+	runLuaTest(t, L, []luaTestData{
+		{`counts.zero`, `0`},
+		{`counts.absent`, `nil`},
+		{`{luar.mapget(counts, "zero")}`, `{0, true}`},
+		{`{luar.mapget(counts, "one")}`, `{1, true}`},
+		{`select(2, luar.mapget(counts, "absent"))`, `false`},
+		{`luar.mapget(counts, "absent")`, `nil`},
+		{`luar.mapget(ptrs, 1).Name`, `"Alice"`},
+		{`select(2, luar.mapget(ptrs, 2))`, `true`},
+		{`select(2, luar.mapget(ptrs, 3))`, `false`},
+		{`pcall(luar.mapget, counts, {})`, `false`},
+		{`pcall(luar.mapget, {}, "zero")`, `false`},
+	})
+}
+
 func (m *mySlice) Foo() int {
 	return len(*m)
 }
 
+func TestProxyMethods(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	type wrapper struct {
+		person
+		Extra  int
+		hidden bool
+	}
+	Register(L, "", Map{
+		"alice": &person{"Alice", 16},
+		"blue":  color(2),
+		"w":     &wrapper{},
+	})
+	// Addressable struct value in a slice element: the pointer methods are still
+	// callable.
+	GoToLuaProxy(L, []person{{"Bob", 17}})
+	L.SetGlobal("people")
+
+	runLuaTest(t, L, []luaTestData{
+		{`luar.methods(alice)`, `{"GetName", "SetName"}`},
+		{`luar.methods(people[1])`, `{"GetName", "SetName"}`},
+		{`people[1].GetName()`, `"Bob"`},
+		{`luar.methods(blue)`, `{"String"}`},
+		{`luar.methods(w)`, `{"GetName", "SetName"}`},
+		{`luar.methods({})`, `{}`},
+		{`luar.fields(alice)`, `{"Name", "Age"}`},
+		{`luar.fields(w)`, `{"Name", "Age", "Extra"}`},
+		{`luar.fields(blue)`, `{}`},
+		{`luar.fields(17)`, `{}`},
+	})
+}
+
+func TestProxyNew(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{
+		"alice":  &person{"Alice", 16},
+		"ages":   map[string]int{"Alice": 16},
+		"scores": []float64{1.5},
+		"events": make(chan string),
+		"a":      myIntA(17),
+		"pair":   &[2]int{1, 2},
+
+		"personType": reflect.TypeOf(person{}),
+		"agesType":   reflect.TypeOf(map[string]int{}),
+	})
+
+	mustDoString(t, L, `
+p = luar.new(alice)
+p.Name = "Bob"
+m = luar.new(ages)
+m.Bob = 17
+s = luar.new(scores, 2, 10)
+s[2] = 2.5
+c = luar.new(events, 1)
+c.send("ready")
+`)
+	runLuaTest(t, L, []luaTestData{
+		{`type(p)`, `"table<*luar.person>"`},
+		{`p.Name`, `"Bob"`},
+		{`p.Age`, `0`},
+		{`p.GetName()`, `"Bob"`},
+		{`alice.Name`, `"Alice"`},
+		{`type(m)`, `"table<map[string]int>"`},
+		{`m.Bob`, `17`},
+		{`ages.Bob`, `nil`},
+		{`#s`, `2`},
+		{`s.cap`, `10`},
+		{`s[2]`, `2.5`},
+		{`#luar.new(scores)`, `0`},
+		{`c.recv()`, `"ready"`},
+		{`luar.new(a).FooIntA()`, `"FooIntA"`},
+		{`#luar.new(pair)`, `2`},
+		{`type(luar.new(personType))`, `"table<*luar.person>"`},
+		{`luar.new(personType).Age`, `0`},
+		{`type(luar.new(agesType, 4))`, `"table<map[string]int>"`},
+		{`pcall(luar.new, {})`, `false`},
+		{`pcall(luar.new, scores, 2, 1)`, `false`},
+	})
+
+	runGoTest(t, L, []goTestData{
+		{`p`, person{"Bob", 0}, ""},
+		{`m`, map[string]int{"Bob": 17}, ""},
+	})
+}
+
+func TestProxyOnFinalize(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	type resource struct {
+		Name   string
+		Closed bool
+	}
+	var closed []string
+	Register(L, "", Map{
+		"release": func(r *resource) {
+			r.Closed = true
+			closed = append(closed, r.Name)
+		},
+	})
+
+	a := &resource{Name: "a"}
+	b := &resource{Name: "b"}
+	c := &resource{Name: "c"}
+	GoToLuaProxy(L, a)
+	L.SetGlobal("a")
+	GoToLuaProxy(L, b)
+	L.SetGlobal("b")
+	GoToLuaProxy(L, c)
+	L.SetGlobal("c")
+
+	mustDoString(t, L, `
+assert(luar.onfinalize(a, release) == a)
+luar.onfinalize(b, release)
+luar.onfinalize(b, nil)
+luar.onfinalize(c, release)
+a, b = nil, nil
+collectgarbage()
+collectgarbage()
+`)
+	if !a.Closed || b.Closed || c.Closed {
+		t.Errorf("got closed %v, want [a]", closed)
+	}
+
+	mustDoString(t, L, `assert(not pcall(luar.onfinalize, {}, release))`)
+	mustDoString(t, L, `assert(not pcall(luar.onfinalize, c, 17))`)
+
+	// The remaining finalizers run when the state is closed.
+	L2 := Init()
+	d := &resource{Name: "d"}
+	GoToLuaProxy(L2, d)
+	L2.SetGlobal("d")
+	Register(L2, "", Map{"release": func(r *resource) { r.Closed = true }})
+	mustDoString(t, L2, `luar.onfinalize(d, release)`)
+	L2.Close()
+	if !d.Closed {
+		t.Error("finalizer not called on close")
+	}
+}
+
+func TestProxySetMeta(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	alice := &person{"Alice", 16}
+	Register(L, "", Map{
+		"alice": alice,
+		"bob":   &person{"Bob", 17},
+	})
+
+	mustDoString(t, L, `
+greeter = luar.setmeta(alice, {
+	__call = function(self, greeting) return greeting .. ", " .. self.Name end,
+	__add = function(a, b) return a.Age + b.Age end,
+	__index = {kind = "person", Name = "shadowed"},
+})
+`)
+	runLuaTest(t, L, []luaTestData{
+		{`alice("Hello")`, `"Hello, shadowed"`},
+		{`rawequal(greeter, alice)`, `true`},
+		{`alice + bob`, `33`},
+		{`alice.kind`, `"person"`},
+		{`alice.Age`, `16`},
+		{`alice.GetName()`, `"Alice"`},
+		{`bob.kind`, `nil`},
+		{`pcall(bob, "Hello")`, `false`},
+		{`luar.typeof(alice).name`, `"person"`},
+	})
+
+	// Assignments still go to the Go value, and a new metatable replaces the
+	// previous one.
+	mustDoString(t, L, `
+alice.Age = 18
+luar.setmeta(alice, {__call = function(self) return self.Name end})
+`)
+	if alice.Age != 18 {
+		t.Errorf("got age %v, want 18", alice.Age)
+	}
+	runLuaTest(t, L, []luaTestData{
+		{`alice()`, `"Alice"`},
+		{`alice.kind`, `nil`},
+		{`pcall(function() return alice + bob end)`, `false`},
+		{`pcall(luar.setmeta, {}, {})`, `false`},
+	})
+}
+
 func TestProxySlice(t *testing.T) {
 	L := Init()
 	defer L.Close()
@@ -1465,6 +3345,97 @@ func TestProxySlice(t *testing.T) {
 		{`a.slice(3, 5)[1]`, `18`},
 		{`a.slice(3, 5)[2]`, `19`},
 	})
+
+	b := []int{10, 20, 30}
+	c := []int{1, 2}
+	grow := func() {
+		c = append(c, 3)
+	}
+	Register(L, "", Map{"b": b, "c": &c, "grow": grow})
+	mustDoString(t, L, `
+sum = 0
+for _, v in ipairs(b) do
+sum = sum + v
+end
+`)
+	runLuaTest(t, L, []luaTestData{{`sum`, `60`}})
+
+	// The iteration reflects the current length.
+	mustDoString(t, L, `
+sum = 0
+for i, v in ipairs(c) do
+sum = sum + v
+if i == 1 then grow() end
+end
+`)
+	runLuaTest(t, L, []luaTestData{{`sum`, `6`}})
+
+	// So does replacing the proxied slice.
+	Register(L, "", Map{"e": []int{1, 2}})
+	mustDoString(t, L, `
+sum = 0
+for i, v in ipairs(e) do
+sum = sum + v
+if i == 1 then luar.append(e, 100) end
+end
+`)
+	runLuaTest(t, L, []luaTestData{{`sum`, `103`}})
+
+	// In-place append.
+	mustDoString(t, L, `d = b; luar.append(b, 40, 50); luar.append(c, 4)`)
+	runLuaTest(t, L, []luaTestData{
+		{`#b`, `5`},
+		{`#d`, `5`},
+		{`b[1]`, `10`},
+		{`d[5]`, `50`},
+		{`#luar.append(luar.slice(), 17)`, `1`},
+	})
+	if len(c) != 4 || c[3] != 4 {
+		t.Errorf("got %v, want [1 2 3 4]", c)
+	}
+
+	// Struct elements alias the backing array.
+	people := []person{{"Alice", 16}, {"Bob", 17}}
+	Register(L, "", Map{"people": people})
+	mustDoString(t, L, `people[2].Name = "Carol"; local p = people[1]; p.Age = 18`)
+	want := []person{{"Alice", 18}, {"Carol", 17}}
+	if !reflect.DeepEqual(people, want) {
+		t.Errorf("got %v, want %v", people, want)
+	}
+	runLuaTest(t, L, []luaTestData{{`people[2].Name`, `"Carol"`}})
+
+	// Sub-slices alias the slice.
+	nums := []int{1, 2, 3, 4, 5}
+	Register(L, "", Map{"nums": nums})
+	mustDoString(t, L, `view = nums:sub(2, 4); view[1] = 20; view[3] = 40`)
+	if want := []int{1, 20, 3, 40, 5}; !reflect.DeepEqual(nums, want) {
+		t.Errorf("got %v, want %v", nums, want)
+	}
+	runLuaTest(t, L, []luaTestData{
+		{`#view`, `3`},
+		{`view[2]`, `3`},
+		{`#nums.sub(3)`, `3`},
+		{`nums.sub(3)[1]`, `3`},
+		{`#nums:sub(6)`, `0`},
+		{`pcall(nums.sub, 0, 2)`, `false`},
+		{`pcall(nums.sub, 2, 6)`, `false`},
+		{`pcall(nums.sub, 4, 2)`, `false`},
+	})
+
+	// Interface elements are converted by their dynamic type.
+	mixed := []interface{}{1, "x", &person{"Dan", 20}, nil}
+	Register(L, "", Map{
+		"mixed": mixed,
+		"team":  map[string]interface{}{"lead": person{"Eve", 30}},
+	})
+	runLuaTest(t, L, []luaTestData{
+		{`{type(mixed[1]), type(mixed[2]), type(mixed[3]), type(mixed[4])}`, `{'number', 'string', 'table<*luar.person>', 'nil'}`},
+		{`mixed[3].Name`, `'Dan'`},
+		{`luar.values(team)[1].Name`, `'Eve'`},
+		{`type(luar.values(team)[1])`, `'table<luar.person>'`},
+		{`(function(t) return {type(t[1]), type(t[2]), type(t[3])} end)(luar.unproxify(mixed))`, `{'number', 'string', 'table'}`},
+		{`luar.unproxify(mixed)[3].Name`, `'Dan'`},
+	})
 }
 
 func TestProxyString(t *testing.T) {
@@ -1496,6 +3467,64 @@ end
 	})
 }
 
+type color int
+
+func (c color) String() string {
+	return [...]string{"red", "green", "blue"}[c]
+}
+
+type counter struct {
+	N int
+}
+
+func (c *counter) String() string {
+	return "counter(" + strconv.Itoa(c.N) + ")"
+}
+
+func TestProxyStringer(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{
+		"green":   color(1),
+		"counter": &counter{N: 3},
+		"person":  person{Name: "Alice", Age: 17},
+		"alice":   &person{Name: "Alice", Age: 17},
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`tostring(green)`, `'green'`},
+		{`"color: " .. green`, `'color: green'`},
+		{`green .. 1`, `'green1'`},
+		{`tostring(counter)`, `'counter(3)'`},
+		{`counter .. "!"`, `'counter(3)!'`},
+		// Without a String method, structs show their type and address, not
+		// their fields.
+		{`tostring(person):match("^luar%.person")`, `'luar.person'`},
+		{`tostring(person):find("Alice")`, `nil`},
+		{`("s" .. person):match("^sluar%.person")`, `'sluar.person'`},
+		{`tostring(person) == luar.tostring(person)`, `true`},
+		{`"s" .. alice == "s" .. luar.tostring(alice)`, `true`},
+	})
+}
+
+type group struct {
+	members []interface{}
+}
+
+func (g *group) Add(items ...interface{}) int {
+	g.members = append(g.members, items...)
+	return len(g.members)
+}
+
+func (g *group) Count(names ...string) int {
+	return len(names)
+}
+
+func (g *group) Label(prefix string, names ...string) string {
+	return prefix + strings.Join(names, ",")
+}
+
 // Get and set public fields in struct proxies.
 // Test interface conversion and calls.
 // Proxies of non-settable structs must not modify the Go value.
@@ -1529,6 +3558,22 @@ func TestProxyStruct(t *testing.T) {
 		{`t.GetName()`, `'Bob'`},
 	})
 
+	// Assigned values are converted to the field type.
+	mustDoString(t, L, `t.Age = 18`)
+	err := L.DoString(`t.Age = 'old'`)
+	if err == nil || !strings.Contains(err.Error(), "struct field Age requires int value type") {
+		t.Errorf("got error %v, want type mismatch", err)
+	}
+	err = L.DoString(`t.Height = 180`)
+	if err == nil || !strings.Contains(err.Error(), "no field named `Height` for type luar.person") {
+		t.Errorf("got error %v, want unknown field", err)
+	}
+	L.SetTop(0)
+	runLuaTest(t, L, []luaTestData{
+		{`t.Age`, `18`},
+		{`pcall(function() t.Name = {} end)`, `false`},
+	})
+
 	mustDoString(t, L, `it = NewName(t)`)
 	runLuaTest(t, L, []luaTestData{
 		{`it.GetName()`, `'Bob'`},
@@ -1538,6 +3583,34 @@ func TestProxyStruct(t *testing.T) {
 		{`type(it)`, `'table<*luar.person>'`},
 	})
 
+	// Methods can be called with colon notation.
+	mustDoString(t, L, `t:SetName("Carol")`)
+	runLuaTest(t, L, []luaTestData{
+		{`t:GetName()`, `'Carol'`},
+		{`t.GetName()`, `'Carol'`},
+		{`it:GetName()`, `'Carol'`},
+	})
+	mustDoString(t, L, `t.SetName("Bob")`)
+	runLuaTest(t, L, []luaTestData{
+		{`t:GetName()`, `'Bob'`},
+	})
+
+	// Variadic methods drop the receiver too, even if they can take it.
+	g := &group{}
+	Register(L, "", Map{"g": g})
+	runLuaTest(t, L, []luaTestData{
+		{`g:Count()`, `0`},
+		{`g:Count('a', 'b')`, `2`},
+		{`g.Count('a', 'b')`, `2`},
+		{`g:Label('x', 'a')`, `'xa'`},
+		{`g.Label('x', 'a', 'b')`, `'xa,b'`},
+		{`g:Add(1)`, `1`},
+		{`g:Add(g)`, `2`},
+	})
+	if len(g.members) != 2 || g.members[1] != g {
+		t.Errorf("got %v, want the group passed as an argument", g.members)
+	}
+
 	want := "Chuck"
 	mustDoString(t, L, `contact.Person.Name = "`+want+`"`)
 	runLuaTest(t, L, []luaTestData{
@@ -1563,29 +3636,484 @@ func TestProxyStruct(t *testing.T) {
 	runLuaTest(t, L, []luaTestData{
 		{`address.City`, `'newCity'`},
 	})
+
+	// Equality compares the Go values, not the proxies.
+	runLuaTest(t, L, []luaTestData{
+		{`NewPerson("Alice", 17) == NewPerson("Alice", 17)`, `true`},
+		{`NewPerson("Alice", 17) == NewPerson("Alice", 18)`, `false`},
+		{`NewPerson("Alice", 17) ~= NewPerson("Bob", 17)`, `true`},
+		{`contact.Person == contact.Person`, `true`},
+		{`contact.Person == contactCopy.Person`, `false`},
+	})
 }
 
-// nil, bool, number, string
-func TestScalar(t *testing.T) {
+type account struct {
+	Owner   string
+	balance int
+	history []int
+}
+
+func TestRaw(t *testing.T) {
 	L := Init()
 	defer L.Close()
 
-	type bar int
-	runGoTest(t, L, []goTestData{
-		{`nil`, "", ""},
-		{`nil`, 0, ""},
-		{`true`, true, ""},
-		{`17`, 17, ""},
-		{`17`, int16(17), ""},
-		{`17`, float32(17), ""},
-		{`17`, bar(17), ""},
-		{`-1.0`, -1, ""},
-		{`-1.7`, -1.7, ""},
-		{`"foo"`, "foo", ""},
+	Register(L, "", Map{
+		"a":  myIntA(17),
+		"s":  myStringA("foo"),
+		"sl": []int{1, 2},
+		"m":  map[string]int{"x": 10},
+		"p":  person{"Alice", 16},
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`type(luar.raw(a))`, `'number'`},
+		{`luar.raw(a) + 1`, `18`},
+		{`luar.raw(s)`, `'foo'`},
+		{`type(luar.raw(sl))`, `'table'`},
+		{`luar.raw(sl)`, `{1, 2}`},
+		{`luar.raw(m)`, `{x=10}`},
+		{`luar.raw(p)`, `{Name='Alice', Age=16}`},
+		{`luar.raw(luar.null)`, `nil`},
+		{`luar.raw(17)`, `17`},
+		{`luar.raw({1})`, `{1}`},
+		{`select('#', luar.raw())`, `1`},
+	})
+}
+
+func TestReadUnexportedFields(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	a := &account{Owner: "Alice", balance: 42, history: []int{10, 32}}
+	Register(L, "", Map{"a": a})
+
+	runLuaTest(t, L, []luaTestData{
+		{`a.Owner`, `'Alice'`},
+		{`a.balance`, `nil`},
+	})
+
+	ReadUnexportedFields = true
+	defer func() { ReadUnexportedFields = false }()
+
+	runLuaTest(t, L, []luaTestData{
+		{`a.balance`, `42`},
+		{`a.history`, `{10, 32}`},
+	})
+
+	err := L.DoString(`a.balance = 17`)
+	if err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("got error %v, want a read-only error", err)
+	}
+	L.SetTop(0)
+
+	// Composite fields are copied.
+	mustDoString(t, L, `a.history[1] = 17`)
+	if a.balance != 42 || a.history[0] != 10 {
+		t.Errorf("unexported fields modified from Lua: %+v", a)
+	}
+}
+
+func TestRegisterAlias(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	calls := 0
+	Register(L, "strs", Map{
+		"upper": func(s string) string {
+			calls++
+			return strings.ToUpper(s)
+		},
+	})
+	Register(L, "", Map{"version": 2})
+
+	if err := RegisterAlias(L, "strs", "upper", "toUpper", "ucase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterAlias(L, "", "version", "VERSION"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterAlias(L, "strs", "lower", "toLower"); err == nil {
+		t.Error("missing error for unknown canonical name")
+	}
+	checkStack(t, L)
+
+	runLuaTest(t, L, []luaTestData{
+		{`strs.toUpper == strs.upper`, `true`},
+		{`rawequal(strs.ucase, strs.upper)`, `true`},
+		{`strs.ucase("foo")`, `"FOO"`},
+		{`VERSION`, `2`},
+		{`strs.toLower`, `nil`},
+	})
+	if calls != 1 {
+		t.Errorf("got %v calls, want 1", calls)
+	}
+}
+
+func TestRegisterAll(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	strs := Map{"upper": strings.ToUpper, "lower": strings.ToLower}
+	nums := Map{"itoa": strconv.Itoa, "pi": 3.14}
+	if err := RegisterAll(L, "lib", strs, nums); err != nil {
+		t.Fatal(err)
+	}
+	runLuaTest(t, L, []luaTestData{
+		{`lib.upper("a") .. lib.lower("B") .. lib.itoa(1)`, `"Ab1"`},
+		{`lib.pi`, `3.14`},
+	})
+
+	// The last definition wins by default.
+	other := Map{"upper": strings.ToLower, "pi": 3}
+	if err := RegisterAll(L, "lax", strs, nums, other); err != nil {
+		t.Fatal(err)
+	}
+	runLuaTest(t, L, []luaTestData{
+		{`lax.upper("A")`, `"a"`},
+		{`lax.pi`, `3`},
+	})
+
+	StrictRegister = true
+	defer func() { StrictRegister = false }()
+
+	// Identical definitions are not conflicts.
+	if err := RegisterAll(L, "same", strs, Map{"upper": strings.ToUpper, "pi": 3.14}, nums); err != nil {
+		t.Error(err)
+	}
+
+	err := RegisterAll(L, "strict", strs, nums, other)
+	want := "conflicting definitions of `pi` (maps #2 and #3), `upper` (maps #1 and #3)"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	runLuaTest(t, L, []luaTestData{{`strict`, `nil`}})
+}
+
+func TestRegisterConstants(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	const (
+		red color = iota
+		green
+	)
+	RegisterConstants(L, "Color", Map{"Red": red, "Green": green})
+
+	runLuaTest(t, L, []luaTestData{
+		{`Color.Red`, `0`},
+		{`Color.Green`, `1`},
+		{`Color.Blue`, `nil`},
+		{`pcall(function() Color.Red = 2 end)`, `false`},
+		{`pcall(function() Color.Blue = 2 end)`, `false`},
+		{`pcall(setmetatable, Color, nil)`, `false`},
+		{`Color.Red`, `0`},
+		{`(function() local n = 0; for k, v in pairs(Color) do n = n + v + 1 end; return n end)()`, `3`},
+	})
+
+	err := L.DoString(`Color.Red = 2`)
+	if err == nil || !strings.Contains(err.Error(), "cannot assign to constant `Red`") {
+		t.Errorf("got error %v, want assignment error", err)
+	}
+}
+
+type logger struct {
+	prefix string
+	lines  []string
+}
+
+func (l logger) Prefix() string {
+	return l.prefix
+}
+
+func (l *logger) Info(msg string) {
+	l.lines = append(l.lines, l.prefix+"INFO "+msg)
+}
+
+func (l *logger) Warn(msg string) {
+	l.lines = append(l.lines, l.prefix+"WARN "+msg)
+}
+
+func TestRegisterMethods(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	l := &logger{prefix: "> "}
+	RegisterMethods(L, "log", l)
+	mustDoString(t, L, `log.Info("foo"); log.Warn(log.Prefix())`)
+
+	want := []string{"> INFO foo", "> WARN > "}
+	if !reflect.DeepEqual(l.lines, want) {
+		t.Errorf("got %q, want %q", l.lines, want)
+	}
+	runLuaTest(t, L, []luaTestData{
+		{`log.prefix`, `nil`},
+	})
+
+	// Values are copied.
+	RegisterMethods(L, "logcopy", logger{prefix: "# "})
+	mustDoString(t, L, `logcopy.Info("bar")`)
+	runLuaTest(t, L, []luaTestData{
+		{`logcopy.Prefix()`, `'# '`},
+	})
+}
+
+func TestRegisterProperty(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	counter := 0
+	RegisterProperty(L, "", "counter", func() interface{} {
+		counter++
+		return counter
+	}, nil)
+
+	level := "info"
+	RegisterProperty(L, "log", "level", func() interface{} {
+		return level
+	}, func(v interface{}) error {
+		s, ok := v.(string)
+		if !ok || (s != "info" && s != "debug") {
+			return fmt.Errorf("invalid level %v", v)
+		}
+		level = s
+		return nil
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`counter`, `1`},
+		{`counter`, `2`},
+		{`counter + counter`, `7`},
+		{`pcall(function() counter = 0 end)`, `false`},
+		{`log.level`, `'info'`},
+		{`pcall(function() log.level = 'verbose' end)`, `false`},
+		{`(function() log.level = 'debug'; return log.level end)()`, `'debug'`},
+		{`(function() log.other = 17; return log.other end)()`, `17`},
+		{`rawget(_G, "counter")`, `nil`},
+	})
+	if level != "debug" {
+		t.Errorf("got level %q, want debug", level)
+	}
+
+	err := L.DoString(`counter = 0`)
+	if err == nil || !strings.Contains(err.Error(), "property `counter` is read-only") {
+		t.Errorf("got error %v, want read-only error", err)
+	}
+
+	// Other globals are not affected.
+	mustDoString(t, L, `x = 17`)
+	runLuaTest(t, L, []luaTestData{
+		{`x`, `17`},
+		{`undefined`, `nil`},
+	})
+	checkStack(t, L)
+}
+
+func TestRegisterType(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	RegisterType(L, "Person", person{})
+	RegisterType(L, "IntA", myIntA(0))
+
+	runLuaTest(t, L, []luaTestData{
+		{`Person.new("Alice", 16).Name`, `"Alice"`},
+		{`Person.new("Alice", 16).Age`, `16`},
+		{`Person.new("Alice").Age`, `0`},
+		{`Person.new("Alice").GetName()`, `"Alice"`},
+		{`type(Person.new())`, `"table<*luar.person>"`},
+		{`Person.is(Person.new())`, `true`},
+		{`Person.is(IntA.new(17))`, `false`},
+		{`Person.is({Name = "Alice"})`, `false`},
+		{`type(IntA.new(17))`, `"number<luar.myIntA>"`},
+		{`IntA.new(17).FooIntA()`, `"FooIntA"`},
+		{`IntA.is(IntA.new(17))`, `true`},
+		{`getmetatable(Person.new()).__name`, `"Person"`},
+		{`getmetatable(Person.new("Alice")).GetName(Person.new("Bob"))`, `"Bob"`},
+	})
+
+	// Proxies made on the Go side use the named metatable too.
+	GoToLuaProxy(L, &person{Name: "Carol"})
+	L.SetGlobal("p")
+	runLuaTest(t, L, []luaTestData{
+		{`getmetatable(p).__name`, `"Person"`},
+		{`p.GetName()`, `"Carol"`},
+		{`p.Name .. "!"`, `"Carol!"`},
+	})
+
+	runGoTest(t, L, []goTestData{
+		{`Person.new("Alice", 16)`, person{"Alice", 16}, ""},
+	})
+
+	for _, code := range []string{
+		`Person.new("Alice", 16, 17)`,
+		`Person.new(16)`,
+	} {
+		if err := L.DoString(code); err == nil {
+			t.Errorf("missing error from %q", code)
+		}
+		L.SetTop(0)
+	}
+}
+
+func TestRegisterTypeName(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	RegisterTypeName("person", &person{})
+	RegisterTypeName("color", color(0))
+	defer RegisterTypeName("person", nil)
+	defer RegisterTypeName("color", nil)
+
+	var got hasName
+	Register(L, "", Map{
+		"getName": getName,
+		"store":   func(o hasName) { got = o },
+		"any":     func(o interface{}) interface{} { return o },
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`getName({__type = "person", Name = "foo", Age = 17})`, `"foo"`},
+		{`pcall(getName, {Name = "foo"})`, `false`},
+		{`pcall(getName, {__type = "unknown", Name = "foo"})`, `false`},
+		{`pcall(getName, {__type = "color"})`, `false`},
+		{`any({__type = "person", Name = "bar"}).Name`, `"bar"`},
+		{`any({__type = "unknown", Name = "bar"}).Name`, `"bar"`},
+	})
+
+	mustDoString(t, L, `store({__type = "person", Name = "foo", Age = 17})`)
+	if p, ok := got.(*person); !ok || *p != (person{Name: "foo", Age: 17}) {
+		t.Errorf("got %#v, want &person{Name: \"foo\", Age: 17}", got)
+	}
+
+	StrictStructs = true
+	defer func() { StrictStructs = false }()
+	runLuaTest(t, L, []luaTestData{
+		{`getName({__type = "person", Name = "baz"})`, `"baz"`},
+	})
+}
+
+func TestRegisterWithErrors(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	parse := func(s string) (int, error) {
+		return strconv.Atoi(s)
+	}
+	RegisterWithErrors(L, "", Map{
+		"parse": parse,
+		"n":     17,
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`parse("17")`, `17`},
+		{`select("#", parse("17"))`, `2`},
+		{`n`, `17`},
+		{`pcall(parse, "foo")`, `false`},
+		{`select(2, pcall(parse, "foo")):find("invalid syntax", 1, true) ~= nil`, `true`},
+		{`select("#", pcall(parse, "foo"))`, `2`},
+	})
+
+	// Register does not raise errors.
+	Register(L, "", Map{"parse": parse})
+	runLuaTest(t, L, []luaTestData{
+		{`tostring(select(2, parse("foo")))`, `'strconv.Atoi: parsing "foo": invalid syntax'`},
+	})
+
+	// The Lua convention can be selected per function.
+	RegisterWithErrors(L, "", Map{"tryParse": ErrorsAsMessages(L, parse)})
+	runLuaTest(t, L, []luaTestData{
+		{`{tryParse("17")}`, `{17}`},
+		{`select("#", tryParse("17"))`, `2`},
+		{`select(2, tryParse("17"))`, `nil`},
+		{`tryParse("foo")`, `nil`},
+		{`select(2, tryParse("foo"))`, `'strconv.Atoi: parsing "foo": invalid syntax'`},
+	})
+}
+
+func TestSandbox(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	s := NewSandbox(L)
+	s.MaxInstructions = 100000
+	err := s.Run(`while true do end`)
+	if err != ErrInstructionLimit {
+		t.Errorf("got error %v, want %v", err, ErrInstructionLimit)
+	}
+	L.SetTop(0)
+	// Catching the error does not let the script carry on.
+	err = s.Run(`while true do pcall(function() while true do end end) end`)
+	if err != ErrInstructionLimit {
+		t.Errorf("got error %v, want %v", err, ErrInstructionLimit)
+	}
+	L.SetTop(0)
+	err = s.Run(`n = 0; for i = 1, 100 do n = n + i end`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runLuaTest(t, L, []luaTestData{{`n`, `5050`}})
+
+	s.MaxInstructions = 0
+	s.MaxMemory = 1 << 20
+	err = s.Run(`t = {}; for i = 1, 1e7 do t[i] = i end`)
+	if err != ErrMemoryLimit {
+		t.Errorf("got error %v, want %v", err, ErrMemoryLimit)
+	}
+	L.SetTop(0)
+}
+
+// nil, bool, number, string
+func TestScalar(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	type bar int
+	runGoTest(t, L, []goTestData{
+		{`nil`, "", ""},
+		{`nil`, 0, ""},
+		{`true`, true, ""},
+		{`17`, 17, ""},
+		{`17`, int16(17), ""},
+		{`17`, float32(17), ""},
+		{`17`, bar(17), ""},
+		{`-1.0`, -1, ""},
+		{`-1.7`, -1.7, ""},
+		{`"foo"`, "foo", ""},
 		{`true`, int16(17), "cannot convert Lua value 'true' (boolean) to int16"},
 		{`17`, "17", "cannot convert Lua value '17' (number) to string"},
+		{`127`, int8(127), ""},
+		{`-128`, int8(-128), ""},
+		{`255.5`, uint8(255), ""},
+		{`128`, int8(0), "cannot convert Lua value '128' (number) to int8"},
+		{`-129`, int8(0), "to int8"},
+		{`300`, uint8(0), "to uint8"},
+		{`-1`, uint(0), "to uint"},
+		{`0/0`, 0, "to int"},
+		{`1/0`, int64(0), "to int64"},
+		{`-1/0`, int64(0), "to int64"},
+		// Both bounds of the widest types.
+		{`-2^63`, int64(-1 << 63), ""},
+		{`2^63`, int64(0), "to int64"},
+		{`-2^63 * 1.5`, int64(0), "to int64"},
+		{`2^63`, uint64(1 << 63), ""},
+		{`2^64`, uint64(0), "to uint64"},
+		// Fractional parts are truncated before the check.
+		{`-128.9`, int8(-128), ""},
+		{`127.9`, int8(127), ""},
+		{`-0.5`, uint8(0), ""},
+		{`256`, uint8(0), "to uint8"},
 	})
 
+	// Without range checks, the result is whatever the Go conversion gives.
+	CheckRanges = false
+	L.PushNumber(300)
+	var u uint8
+	if err := LuaToGo(L, -1, &u); err != nil {
+		t.Error(err)
+	}
+	L.Pop(1)
+	CheckRanges = true
+
 	var i interface{}
 
 	L.PushNil()
@@ -1623,6 +4151,155 @@ func TestScalar(t *testing.T) {
 	} else if ifloat != 17 {
 		t.Errorf("got %v, expected '17' from Lua conversion to Go interface", i)
 	}
+
+	// Integers that Lua numbers cannot hold exactly are passed as proxies.
+	max := ^uint64(0)
+	GoToLua(L, max)
+	L.SetGlobal("max")
+	runLuaTest(t, L, []luaTestData{
+		{`tostring(max)`, `"18446744073709551615"`},
+		{`"x" .. max`, `"x18446744073709551615"`},
+		{`max .. "x"`, `"18446744073709551615x"`},
+	})
+	var exact uint64
+	L.GetGlobal("max")
+	err = LuaToGo(L, -1, &exact)
+	L.Pop(1)
+	if err != nil || exact != max {
+		t.Errorf("got %v (%v), want %v", exact, err, max)
+	}
+}
+
+func TestSetGlobalHook(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	config := map[string]int{"level": 1}
+	Register(L, "", Map{"config": config})
+
+	written := map[string]bool{}
+	SetGlobalHook(L, func(name string) (interface{}, bool, error) {
+		switch name {
+		case "version":
+			return "1.2", true, nil
+		case "secret":
+			return nil, false, errors.New("access to `secret` denied")
+		}
+		return nil, false, nil
+	}, func(name string, v interface{}) (bool, error) {
+		if name == "config" {
+			return false, errors.New("`config` is read-only")
+		}
+		if name == "discarded" {
+			return true, nil
+		}
+		written[name] = true
+		return false, nil
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`pcall(function() config = {} end)`, `false`},
+		{`select(2, pcall(function() config = {} end)):find("read-only", 1, true) ~= nil`, `true`},
+		{`config.level`, `1`},
+		{`version`, `"1.2"`},
+		{`pcall(function() return secret end)`, `false`},
+		{`type(print)`, `"function"`},
+		{`(function() x = 17; return x end)()`, `17`},
+		{`(function() discarded = 17; return discarded end)()`, `nil`},
+		{`(function() function double(n) return 2*n end; return double(2) end)()`, `4`},
+		{`(function() for k in pairs(_G) do if k == "x" then return true end end end)()`, `true`},
+		{`pcall(function() co = coroutine.create(function() end) end)`, `false`},
+		{`co`, `nil`},
+	})
+	// The test helpers are written as well.
+	if !written["x"] || !written["double"] || written["config"] || written["discarded"] {
+		t.Errorf("got writes %v, want x and double", written)
+	}
+	checkStack(t, L)
+
+	// Go accesses are hooked too.
+	L.PushInteger(18)
+	L.SetGlobal("y")
+	L.GetGlobal("version")
+	if v := L.ToString(-1); v != "1.2" {
+		t.Errorf("got version %q, want 1.2", v)
+	}
+	L.Pop(1)
+
+	SetGlobalHook(L, nil, nil)
+	checkStack(t, L)
+	runLuaTest(t, L, []luaTestData{
+		{`rawget(_G, "x")`, `17`},
+		{`y`, `18`},
+		{`version`, `nil`},
+		{`(function() config = 2; return config end)()`, `2`},
+		{`getmetatable(_G)`, `nil`},
+	})
+
+	// The previous metatable of the globals still applies and is restored.
+	mustDoString(t, L, `setmetatable(_G, {
+	__index = function(_, k) error("undeclared global " .. k, 2) end,
+	__newindex = function(t, k, v)
+		if k ~= "declared" then error("undeclared global " .. k, 2) end
+		rawset(t, k, v)
+	end,
+})`)
+	SetGlobalHook(L, nil, func(name string, v interface{}) (bool, error) {
+		return false, nil
+	})
+	runLuaTest(t, L, []luaTestData{
+		{`pcall(function() return undeclared end)`, `false`},
+		{`pcall(function() undeclared = 1 end)`, `false`},
+		{`(function() declared = 1; return declared end)()`, `1`},
+	})
+	SetGlobalHook(L, nil, nil)
+	checkStack(t, L)
+	runLuaTest(t, L, []luaTestData{
+		{`rawget(_G, "declared")`, `1`},
+		{`pcall(function() return undeclared end)`, `false`},
+	})
+}
+
+func TestSharedGoToLua(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	sub := []int{17, 18}
+	GoToLua(L, [][]int{sub, sub, sub[:1]})
+	L.SetGlobal("t")
+	mustDoString(t, L, `return t[1] == t[2], t[1] == t[3], #t[3]`)
+	if !L.ToBoolean(-3) {
+		t.Error("shared slice converted to distinct tables")
+	}
+	if L.ToBoolean(-2) {
+		t.Error("reslice converted to the same table as the original slice")
+	}
+	if n := L.ToInteger(-1); n != 1 {
+		t.Errorf("got reslice length %v, want 1", n)
+	}
+	L.Pop(3)
+
+	m := map[string]int{"foo": 17}
+	GoToLua(L, map[string]interface{}{"a": m, "b": m})
+	L.SetGlobal("t")
+	mustDoString(t, L, `return t.a == t.b`)
+	if !L.ToBoolean(-1) {
+		t.Error("shared map converted to distinct tables")
+	}
+	L.Pop(1)
+
+	// A struct and its first field share their address, not their table.
+	type pair struct {
+		First [2]int
+	}
+	p := &pair{First: [2]int{1, 2}}
+	GoToLua(L, []interface{}{p, &p.First})
+	L.SetGlobal("t")
+	runLuaTest(t, L, []luaTestData{
+		{`t[1].First`, `{1, 2}`},
+		{`t[2]`, `{1, 2}`},
+	})
+	checkStack(t, L)
 }
 
 func TestSlice(t *testing.T) {
@@ -1691,6 +4368,118 @@ func TestSlice(t *testing.T) {
 	}
 }
 
+func TestSliceIndices(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	s := []int{10, 20}
+	GoToLuaProxy(L, s)
+	L.SetGlobal("s")
+	GoToLua(L, s)
+	L.SetGlobal("tab")
+	GoToLuaProxy(L, &[2]int{10, 20})
+	L.SetGlobal("arr")
+	GoToLuaProxy(L, [][]int{{10, 20}, {30}})
+	L.SetGlobal("nested")
+	GoToLua(L, [][]int{{10, 20}, {30}})
+	L.SetGlobal("nestedTab")
+
+	runLuaTest(t, L, []luaTestData{
+		{`s[1]`, `10`},
+		{`s[2]`, `20`},
+		{`pcall(function() return s[0] end)`, `false`},
+		{`pcall(function() return s[3] end)`, `false`},
+		{`pcall(function() return s[1.5] end)`, `false`},
+		{`pcall(function() s[1.5] = 0 end)`, `false`},
+		{`{ipairs(s)(s, 0)}`, `{1, 10}`},
+		{`tab[1]`, `10`},
+		{`tab[0]`, `nil`},
+		{`luar.unproxify(s)[1]`, `10`},
+		{`arr[1]`, `10`},
+		{`luar.unproxify(arr)[2]`, `20`},
+		{`nested[2][1]`, `30`},
+		{`nestedTab[2][1]`, `30`},
+		{`luar.table2slice({10, 20}, "int")[1]`, `10`},
+		{`s.slice(1, 2)[1]`, `10`},
+		{`#s.slice(1, 3)`, `2`},
+		{`#s.slice(3, 3)`, `0`},
+		{`#luar.slice(0).slice(1, 1)`, `0`},
+		{`pcall(s.slice, 0, 1)`, `false`},
+		{`pcall(s.slice, 1, 4)`, `false`},
+		{`s:sub(2)[1]`, `20`},
+	})
+
+	mustDoString(t, L, `s[1] = 11; arr[2] = 21`)
+	if s[0] != 11 {
+		t.Errorf("got %v, want 11", s[0])
+	}
+
+	runGoTest(t, L, []goTestData{
+		{`{10, 20}`, []int{10, 20}, ""},
+		{`{10, 20}`, [2]int{10, 20}, ""},
+		{`{{10, 20}, {30}}`, [][]int{{10, 20}, {30}}, ""},
+	})
+}
+
+func TestSpreadSliceReturn(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{
+		"values": func() []interface{} { return []interface{}{1, 2, 3} },
+		"none":   func() []interface{} { return nil },
+		"ints":   func() []int { return []int{1, 2, 3} },
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`select('#', values())`, `1`},
+		{`#values()`, `3`},
+	})
+
+	SpreadSliceReturn = true
+	defer func() { SpreadSliceReturn = false }()
+	runLuaTest(t, L, []luaTestData{
+		{`select('#', values())`, `3`},
+		{`{values()}`, `{1, 2, 3}`},
+		{`select('#', none())`, `0`},
+		{`select('#', ints())`, `1`},
+	})
+}
+
+func TestStatePool(t *testing.T) {
+	pool := &StatePool{
+		New: func() *lua.State {
+			L := Init()
+			Register(L, "", Map{"n": 17})
+			return L
+		},
+		Reset:   `x = nil`,
+		MaxIdle: 1,
+	}
+	defer pool.Close()
+
+	L := pool.Get()
+	mustDoString(t, L, `x = n + 1`)
+	runLuaTest(t, L, []luaTestData{{`x`, `18`}})
+	pool.Put(L)
+
+	L2 := pool.Get()
+	if L2 != L {
+		t.Error("state was not reused")
+	}
+	runLuaTest(t, L2, []luaTestData{{`x`, `nil`}, {`n`, `17`}})
+
+	L3 := pool.Get()
+	if L3 == L2 {
+		t.Error("state in use was returned")
+	}
+	pool.Put(L2)
+	pool.Put(L3)
+	if len(pool.states) != 1 {
+		t.Errorf("got %v idle states, want 1", len(pool.states))
+	}
+}
+
 type Contact struct {
 	Person person
 }
@@ -1759,6 +4548,523 @@ func TestStruct(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("got %#v, want %#v from Lua->Go conversion of `%v`", got, want, input)
 	}
+
+	L.Pop(1)
+
+	// Keys can fall back to case-insensitive matching. Nested tables are converted
+	// recursively.
+	type config struct {
+		Owner person
+		Tags  []string
+		Port  int
+	}
+	runGoTest(t, L, []goTestData{
+		{`{name='Alice', age=16}`, person{}, ""},
+		{`{Name='Alice', Age=16}`, person{Name: "Alice", Age: 16}, ""},
+	})
+
+	FoldFieldNames = true
+	defer func() { FoldFieldNames = false }()
+	runGoTest(t, L, []goTestData{
+		{`{name='Alice', age=16}`, person{Name: "Alice", Age: 16}, ""},
+		{`{owner={name='Alice', age=16}, tags={'a', 'b'}, port=8080, unknown=true}`, config{Owner: person{Name: "Alice", Age: 16}, Tags: []string{"a", "b"}, Port: 8080}, ""},
+	})
+
+	StrictStructs = true
+	defer func() { StrictStructs = false }()
+	runGoTest(t, L, []goTestData{
+		{`{name='Alice', age=16}`, person{Name: "Alice", Age: 16}, ""},
+		{`{name='Alice', nickname='Al', alias='Ali'}`, person{}, `unknown keys "alias", "nickname"`},
+	})
+}
+
+type Office struct {
+	City string
+}
+
+type employee struct {
+	person
+	*Office
+	Role string
+}
+
+func TestStructEmbedded(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	e := &employee{person: person{Name: "Alice", Age: 16}, Role: "dev"}
+	Register(L, "", Map{"e": e})
+
+	runLuaTest(t, L, []luaTestData{
+		{`e.Name`, `"Alice"`},
+		{`e.Role`, `"dev"`},
+		{`pcall(function() return e.City end)`, `false`},
+	})
+
+	mustDoString(t, L, `e.Age = 17`)
+	if e.Age != 17 {
+		t.Errorf("got %v, want 17", e.Age)
+	}
+
+	e.Office = &Office{City: "Paris"}
+	runLuaTest(t, L, []luaTestData{{`e.City`, `"Paris"`}})
+
+	runGoTest(t, L, []goTestData{
+		{`{Name='Bob', City='Lyon', Role='ops'}`, employee{person: person{Name: "Bob"}, Office: &Office{City: "Lyon"}, Role: "ops"}, ""},
+	})
+}
+
+type profile struct {
+	FullName string
+	UserID   int
+	Nick     string `lua:"nickname"`
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 && !unicode.IsUpper(rune(name[i-1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func TestStructFieldNamer(t *testing.T) {
+	SetFieldNamer(snakeCase)
+	defer SetFieldNamer(nil)
+
+	L := Init()
+	defer L.Close()
+
+	p := &profile{FullName: "Alice Doe", UserID: 17, Nick: "Al"}
+	Register(L, "", Map{"p": p})
+	runLuaTest(t, L, []luaTestData{
+		{`p.full_name`, `"Alice Doe"`},
+		{`p.user_id`, `17`},
+		{`p.nickname`, `"Al"`},
+		{`p.FullName`, `nil`},
+	})
+
+	mustDoString(t, L, `p.full_name = "Bob Doe"`)
+	if p.FullName != "Bob Doe" {
+		t.Errorf("got %q, want %q", p.FullName, "Bob Doe")
+	}
+
+	GoToLua(L, *p)
+	L.SetGlobal("c")
+	runLuaTest(t, L, []luaTestData{{`c`, `{full_name="Bob Doe", user_id=17, nickname="Al"}`}})
+	runGoTest(t, L, []goTestData{{`{full_name="Carl Doe", user_id=18}`, profile{FullName: "Carl Doe", UserID: 18}, ""}})
+}
+
+type personWithHidden struct {
+	FullName string `lua:"full_name"`
+	Secret   string `lua:"-"`
+	Name     string
+	Nick     string `lua:"Name"`
+}
+
+type command struct {
+	Args []string `lua:",rest"`
+	Mode string   `lua:"mode"`
+	One  string   `lua:"1"`
+}
+
+func TestStructRest(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	runGoTest(t, L, []goTestData{
+		{`{"a", "b", mode="fast"}`, command{Args: []string{"a", "b"}, Mode: "fast"}, ""},
+		{`{mode="slow"}`, command{Args: []string{}, Mode: "slow"}, ""},
+		// Integer keys beyond the sequence map to fields as usual.
+		{`{[3]="c"}`, command{Args: []string{}}, ""},
+		// The sequence has priority over the field named "1", which only gets
+		// string keys.
+		{`{"a", ["1"]="one"}`, command{Args: []string{"a"}, One: "one"}, ""},
+		{`{"a", 17}`, command{}, ErrTableConv.Error()},
+		// The name of the rest field does not overwrite the sequence.
+		{`{"a", Args={"x"}}`, command{Args: []string{"a"}}, ""},
+	})
+
+	GoToLua(L, command{Args: []string{"a", "b"}, Mode: "fast"})
+	L.SetGlobal("c")
+	runLuaTest(t, L, []luaTestData{{`c`, `{"a", "b", mode="fast", ["1"]=""}`}})
+
+	StrictStructs = true
+	defer func() { StrictStructs = false }()
+	runGoTest(t, L, []goTestData{
+		{`{"a", Args={"x"}}`, command{Args: []string{"a"}}, `unknown keys "Args"`},
+	})
+}
+
+func TestStructTags(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	p := &personWithHidden{FullName: "Alice Doe", Secret: "foo", Name: "Alice", Nick: "Al"}
+	Register(L, "", Map{"p": p, "c": *p})
+
+	runLuaTest(t, L, []luaTestData{
+		{`p.full_name`, `"Alice Doe"`},
+		{`p.FullName`, `nil`},
+		{`p.Secret`, `nil`},
+		// The tag has priority over the field name.
+		{`p.Name`, `"Al"`},
+		{`luar.unproxify(c)`, `{full_name = "Alice Doe", Name = "Al"}`},
+		{`pcall(function() p.Secret = "bar" end)`, `false`},
+		{`pcall(function() p.FullName = "bar" end)`, `false`},
+	})
+
+	mustDoString(t, L, `p.full_name = "Bob Doe"; p.Name = "Bobby"`)
+	want := personWithHidden{FullName: "Bob Doe", Secret: "foo", Name: "Alice", Nick: "Bobby"}
+	if *p != want {
+		t.Errorf("got %#v, want %#v", *p, want)
+	}
+
+	runGoTest(t, L, []goTestData{
+		{`{full_name = "Carl Doe", Secret = "bar", Name = "Carl"}`, personWithHidden{FullName: "Carl Doe", Nick: "Carl"}, ""},
+	})
+}
+
+func TestSyncState(t *testing.T) {
+	s := NewSyncState(Init())
+	defer s.Close()
+
+	var add *LuaObject
+	err := s.Do(func(L *lua.State) error {
+		if err := L.DoString(`n = 0; function add(k) n = n + k; return n end`); err != nil {
+			return err
+		}
+		add = NewLuaObjectFromName(L, "add")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := s.DoString(`n = n + 1`); err != nil {
+					t.Error(err)
+				}
+				res := new(int)
+				if err := s.Call(add, &res, 1); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	_ = s.Do(func(L *lua.State) error {
+		L.GetGlobal("n")
+		if n := L.ToInteger(-1); n != 2000 {
+			t.Errorf("got %v, want 2000", n)
+		}
+		L.Pop(1)
+		checkStack(t, L)
+		add.Close()
+		return nil
+	})
+}
+
+func TestTableChannels(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{
+		"sum": func(ch <-chan int) int {
+			total := 0
+			for i := range ch {
+				total += i
+			}
+			return total
+		},
+		"first": func(ch chan string) string {
+			return <-ch
+		},
+		"feed": func(ch chan<- int) {},
+	})
+
+	runLuaTest(t, L, []luaTestData{
+		{`pcall(sum, {1, 2, 3})`, `false`},
+	})
+
+	TableChannels = true
+	defer func() { TableChannels = false }()
+	runLuaTest(t, L, []luaTestData{
+		{`sum({1, 2, 3})`, `6`},
+		{`sum({})`, `0`},
+		{`first({"a", "b"})`, `"a"`},
+		{`pcall(sum, {1, "two"})`, `false`},
+		{`pcall(feed, {1})`, `false`},
+	})
+
+	var ch chan int
+	mustDoString(t, L, `return {4, 5}`)
+	if err := LuaToGo(L, -1, &ch); err != nil {
+		t.Fatal(err)
+	}
+	L.Pop(1)
+	var got []int
+	for i := range ch {
+		got = append(got, i)
+	}
+	if !reflect.DeepEqual(got, []int{4, 5}) {
+		t.Errorf("got %v, want [4 5]", got)
+	}
+}
+
+func TestTableToMap(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	runGoTest(t, L, []goTestData{
+		{`luar.table2map({foo = 17, bar = "baz"})`, map[string]interface{}{"foo": 17.0, "bar": "baz"}, ""},
+		{`luar.table2map({foo = 17, bar = 18}, "int")`, map[string]int{"foo": 17, "bar": 18}, ""},
+		{`luar.table2map({})`, map[string]interface{}{}, ""},
+	})
+
+	// The result is a live map proxy.
+	runLuaTest(t, L, []luaTestData{
+		{`type(luar.table2map({foo = 17}, "int"))`, `"table<map[string]int>"`},
+		{`luar.table2map({foo = 17}, "int").foo`, `17`},
+	})
+
+	for _, code := range []string{
+		`luar.table2map({17})`,
+		`luar.table2map({foo = "bar"}, "int")`,
+		`luar.table2map({foo = 17}, "foo")`,
+	} {
+		if err := L.DoString(code); err == nil {
+			t.Errorf("missing error from %q", code)
+		}
+		L.SetTop(0)
+	}
+}
+
+func TestTableToSlice(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	runGoTest(t, L, []goTestData{
+		{`luar.table2slice({17, "foo"})`, []interface{}{17.0, "foo"}, ""},
+		{`luar.table2slice({17, 18}, "int")`, []int{17, 18}, ""},
+		{`luar.table2slice({"foo", "bar", nil, "baz"}, "string")`, []string{"foo", "bar"}, ""},
+		{`luar.table2slice({})`, []interface{}{}, ""},
+	})
+
+	// The result is a live slice proxy.
+	runLuaTest(t, L, []luaTestData{
+		{`type(luar.table2slice({17}, "int"))`, `"table<[]int>"`},
+		{`#luar.table2slice({17, 18}, "int").append(19)`, `3`},
+	})
+
+	for _, code := range []string{
+		`luar.table2slice({17, foo = 18})`,
+		`luar.table2slice({[1.5] = 17})`,
+		`luar.table2slice({"foo"}, "int")`,
+		`luar.table2slice({17}, "foo")`,
+	} {
+		if err := L.DoString(code); err == nil {
+			t.Errorf("missing error from %q", code)
+		}
+		L.SetTop(0)
+	}
+}
+
+type event struct {
+	Name string
+	At   time.Time
+}
+
+type level int
+
+const (
+	levelLow level = iota
+	levelHigh
+)
+
+func (l level) MarshalText() ([]byte, error) {
+	switch l {
+	case levelLow:
+		return []byte("low"), nil
+	case levelHigh:
+		return []byte("high"), nil
+	}
+	return nil, fmt.Errorf("invalid level %d", int(l))
+}
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = levelLow
+	case "high":
+		*l = levelHigh
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+	return nil
+}
+
+type alert struct {
+	Level level
+	Tags  []level
+}
+
+func TestTextMarshalers(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	TextMarshalers = true
+	defer func() { TextMarshalers = false }()
+
+	Register(L, "", Map{
+		"high":    levelHigh,
+		"invalid": level(7),
+		"raise": func(l level) level {
+			if l < levelHigh {
+				l++
+			}
+			return l
+		},
+	})
+	GoToLua(L, alert{levelHigh, []level{levelLow, levelHigh}})
+	L.SetGlobal("a")
+
+	runLuaTest(t, L, []luaTestData{
+		{`high`, `"high"`},
+		{`raise("low")`, `"high"`},
+		{`a`, `{Level="high", Tags={"low", "high"}}`},
+		{`type(invalid)`, `"number<luar.level>"`},
+		{`pcall(raise, "medium")`, `false`},
+	})
+
+	runGoTest(t, L, []goTestData{
+		{`"high"`, levelHigh, ""},
+		{`{Level="low", Tags={"high"}}`, alert{levelLow, []level{levelHigh}}, ""},
+		{`"medium"`, levelLow, "unknown level"},
+		{`1`, levelHigh, ""},
+	})
+
+	// Off by default.
+	TextMarshalers = false
+	Register(L, "", Map{"high": levelHigh})
+	runLuaTest(t, L, []luaTestData{{`type(high)`, `"number<luar.level>"`}})
+}
+
+func TestTime(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	date := time.Date(2017, 5, 18, 10, 30, 15, 0, time.Local)
+	e := &event{Name: "launch", At: date}
+	Register(L, "", Map{"date": date, "e": e})
+
+	runLuaTest(t, L, []luaTestData{
+		{`date.year`, `2017`},
+		{`date.month`, `5`},
+		{`date.day`, `18`},
+		{`date.hour`, `10`},
+		{`date.min`, `30`},
+		{`date.sec`, `15`},
+		{`date.wday`, `os.date("*t", os.time(date)).wday`},
+		{`e.At.year`, `2017`},
+	})
+
+	runGoTest(t, L, []goTestData{
+		{`date`, date, ""},
+		{`{year = 2017, month = 5, day = 18}`, time.Date(2017, 5, 18, 0, 0, 0, 0, time.Local), ""},
+		{`os.time(date)`, date, ""},
+		{`{year = 2017}`, time.Time{}, "cannot convert"},
+		{`"foo"`, time.Time{}, "cannot convert"},
+	})
+
+	mustDoString(t, L, `e.At = {year = 2018, month = 1, day = 2}`)
+	if want := time.Date(2018, 1, 2, 0, 0, 0, 0, time.Local); !e.At.Equal(want) {
+		t.Errorf("got %v, want %v", e.At, want)
+	}
+
+	TimeAsUnix = true
+	defer func() { TimeAsUnix = false }()
+	Register(L, "", Map{"date": date})
+	runLuaTest(t, L, []luaTestData{
+		{`date`, strconv.FormatInt(date.Unix(), 10)},
+		{`date == os.time({year = 2017, month = 5, day = 18, hour = 10, min = 30, sec = 15})`, `true`},
+	})
+	runGoTest(t, L, []goTestData{
+		{`date`, date, ""},
+	})
+
+	Register(L, "", Map{"zero": time.Time{}})
+	runLuaTest(t, L, []luaTestData{
+		{`zero`, strconv.FormatInt(time.Time{}.Unix(), 10)},
+	})
+	var zero time.Time
+	L.GetGlobal("zero")
+	if err := LuaToGo(L, -1, &zero); err != nil || !zero.IsZero() {
+		t.Errorf("got %v (%v), want the zero time", zero, err)
+	}
+	L.Pop(1)
+}
+
+func TestToString(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	alice := &person{"Alice", 16}
+	Register(L, "", Map{
+		"alice":   alice,
+		"blue":    color(2),
+		"counter": &counter{N: 3},
+		"err":     errors.New("boom"),
+		"address": fmt.Sprintf("luar.person@%p", alice),
+	})
+	GoToLuaProxy(L, []int{17, 18})
+	L.SetGlobal("ints")
+
+	runLuaTest(t, L, []luaTestData{
+		{`luar.tostring(blue)`, `"blue"`},
+		{`luar.tostring(counter)`, `"counter(3)"`},
+		{`luar.tostring(err)`, `"boom"`},
+		{`luar.tostring(alice) == address`, `true`},
+		{`luar.tostring(ints)`, `"[17 18]"`},
+		{`luar.tostring(17)`, `"17"`},
+		{`luar.tostring("foo")`, `"foo"`},
+		{`luar.tostring(nil)`, `"nil"`},
+		{`luar.tostring(true)`, `"true"`},
+		{`luar.tostring(setmetatable({}, {__tostring = function() return "custom" end}))`, `"custom"`},
+	})
+}
+
+func TestTypeOf(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	Register(L, "", Map{
+		"p": &person{Name: "Alice", Age: 16},
+		"s": []int{1, 2},
+	})
+	mustDoString(t, L, `desc = luar.typeof(p)`)
+	runLuaTest(t, L, []luaTestData{
+		{`desc.kind`, `"struct"`},
+		{`desc.name`, `"person"`},
+		{`desc.string`, `"*luar.person"`},
+		{`desc.fields`, `{"Name", "Age"}`},
+		{`desc.methods`, `{"GetName", "SetName"}`},
+		{`luar.typeof(s).kind`, `"slice"`},
+		{`luar.typeof(s).name`, `nil`},
+		{`luar.typeof({}).kind`, `"table"`},
+	})
 }
 
 // 'nil' in Go slices and maps is represented by luar.null.
@@ -1781,3 +5087,45 @@ func TestUnproxify(t *testing.T) {
 	mustDoString(t, L, `tm = luar.unproxify(m)`)
 	runLuaTest(t, L, []luaTestData{{`tm`, `{a={1, 2}, b=luar.null, c={10, 20}, d=luar.null}`}})
 }
+
+func TestUnwrapProxy(t *testing.T) {
+	L := Init()
+	defer L.Close()
+
+	s := []int{17, 18}
+	GoToLuaProxy(L, s)
+	if !IsProxy(L, -1) {
+		t.Error("slice was not pushed as a proxy")
+	}
+	v, ok := UnwrapProxy(L, -1)
+	if !ok {
+		t.Fatal("cannot unwrap slice proxy")
+	}
+	got, ok := v.Interface().([]int)
+	if !ok || &got[0] != &s[0] {
+		t.Errorf("got %#v, want the original slice", v.Interface())
+	}
+	L.SetGlobal("s")
+
+	mustDoString(t, L, `s[1] = 19; return luar.slice(1)`)
+	if v, ok := UnwrapProxy(L, -1); !ok || v.Type() != tslice {
+		t.Errorf("got %v, want a proxy of type %v", v, tslice)
+	}
+	L.Pop(1)
+	if s[0] != 19 {
+		t.Errorf("got %v, want 19", s[0])
+	}
+
+	L.PushInteger(17)
+	L.NewTable()
+	for _, idx := range []int{-1, -2} {
+		if IsProxy(L, idx) {
+			t.Errorf("value at %v is not a proxy", idx)
+		}
+		if _, ok := UnwrapProxy(L, idx); ok {
+			t.Errorf("unwrapped non-proxy value at %v", idx)
+		}
+	}
+	L.Pop(2)
+	checkStack(t, L)
+}
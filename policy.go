@@ -0,0 +1,75 @@
+package luar
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ConversionPolicy decides whether the arrays, slices, maps and structs of a
+// type are passed to Lua as proxies or copied to tables. See
+// SetConversionPolicy.
+type ConversionPolicy int
+
+const (
+	// ConvertDefault proxifies in GoToLuaProxy and copies in GoToLua.
+	ConvertDefault ConversionPolicy = iota
+	// ConvertToProxy always proxifies.
+	ConvertToProxy
+	// ConvertToTable always copies to a table.
+	ConvertToTable
+)
+
+var (
+	policies   = map[reflect.Type]ConversionPolicy{}
+	policiesMu sync.RWMutex
+	// policiesLen is the number of policies, read without the lock so that
+	// conversions do not contend on it when no policy is set.
+	policiesLen int32
+)
+
+// SetConversionPolicy sets how the values of type 't' are converted by
+// GoToLua, GoToLuaProxy and the values returned by Go functions. For instance,
+// small configuration maps can be copied to native tables while large data maps
+// stay proxies.
+//
+// The policy applies to the type once pointers are followed, so it also applies
+// to the values of type '*t'. It is consulted for every value, including the
+// elements of converted containers. ConvertDefault removes the policy of 't'.
+//
+// Only arrays, slices, maps and structs are affected.
+func SetConversionPolicy(t reflect.Type, p ConversionPolicy) {
+	policiesMu.Lock()
+	if p == ConvertDefault {
+		delete(policies, t)
+	} else {
+		policies[t] = p
+	}
+	atomic.StoreInt32(&policiesLen, int32(len(policies)))
+	policiesMu.Unlock()
+}
+
+// applyPolicy returns whether the value of type 't' must be proxified, given
+// the default 'proxify'.
+func applyPolicy(t reflect.Type, proxify bool) bool {
+	switch t.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.Struct:
+	default:
+		return proxify
+	}
+	if atomic.LoadInt32(&policiesLen) == 0 {
+		return proxify
+	}
+
+	policiesMu.RLock()
+	p := policies[t]
+	policiesMu.RUnlock()
+
+	switch p {
+	case ConvertToProxy:
+		return true
+	case ConvertToTable:
+		return false
+	}
+	return proxify
+}
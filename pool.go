@@ -0,0 +1,78 @@
+package luar
+
+import (
+	"sync"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// StatePool keeps initialized Lua states for reuse, since creating and
+// initializing a state is expensive. The zero value is an unlimited pool of
+// states created with Init.
+//
+// A state must only be used by one goroutine between Get and Put.
+type StatePool struct {
+	// New creates the states, e.g. to register values after Init. It defaults
+	// to Init.
+	New func() *lua.State
+	// Reset is run on the states put back in the pool, e.g. to clear the
+	// globals set by a script. A state for which it fails is closed.
+	Reset string
+	// MaxIdle is the maximum number of states kept in the pool. The states put
+	// beyond are closed. Zero means no limit.
+	MaxIdle int
+
+	mu     sync.Mutex
+	states []*lua.State
+	closed bool
+}
+
+// Get returns a state from the pool, or a new one if the pool is empty.
+func (p *StatePool) Get() *lua.State {
+	p.mu.Lock()
+	if n := len(p.states); n > 0 {
+		L := p.states[n-1]
+		p.states = p.states[:n-1]
+		p.mu.Unlock()
+		return L
+	}
+	p.mu.Unlock()
+
+	if p.New != nil {
+		return p.New()
+	}
+	return Init()
+}
+
+// Put resets 'L' and puts it back in the pool. 'L' must not be used afterwards.
+func (p *StatePool) Put(L *lua.State) {
+	L.SetTop(0)
+	if p.Reset != "" {
+		err := L.DoString(p.Reset)
+		if err != nil {
+			L.Close()
+			return
+		}
+		L.SetTop(0)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || (p.MaxIdle > 0 && len(p.states) >= p.MaxIdle) {
+		L.Close()
+		return
+	}
+	p.states = append(p.states, L)
+}
+
+// Close closes the states in the pool. The states in use are closed when they
+// are put back.
+func (p *StatePool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, L := range p.states {
+		L.Close()
+	}
+	p.states = nil
+	p.closed = true
+}
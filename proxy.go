@@ -2,9 +2,11 @@ package luar
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/aarzilli/golua/lua"
 )
@@ -14,6 +16,11 @@ import (
 type valueProxy struct {
 	v reflect.Value
 	t reflect.Type
+	// readOnly is set by GoToLuaReadOnly and inherited by the nested proxies.
+	readOnly bool
+	// finalizer is the registry reference of the function set by
+	// ProxyOnFinalize, or 0.
+	finalizer int
 }
 
 const (
@@ -33,6 +40,22 @@ var (
 	proxymu        sync.RWMutex
 )
 
+// appendToSlice converts the Lua values from index 'first' to the top of the
+// stack to the element type of the slice 'v' and returns the appended slice.
+func appendToSlice(L *lua.State, v reflect.Value, first int) reflect.Value {
+	narg := L.GetTop()
+	args := []reflect.Value{}
+	for i := first; i <= narg; i++ {
+		elem := reflect.New(v.Type().Elem())
+		err := LuaToGo(L, i, elem.Interface())
+		if err != nil {
+			L.RaiseError(fmt.Sprintf("slice requires %v value type", v.Type().Elem()))
+		}
+		args = append(args, elem.Elem())
+	}
+	return reflect.Append(v, args...)
+}
+
 // commonKind returns the kind to which v1 and v2 can be converted with the
 // least information loss.
 func commonKind(v1, v2 reflect.Value) reflect.Kind {
@@ -47,6 +70,85 @@ func commonKind(v1, v2 reflect.Value) reflect.Kind {
 	return reflect.Float64
 }
 
+// copyKey identifies the references already copied by deepCopy.
+type copyKey struct {
+	ptr uintptr
+	t   reflect.Type
+	len int
+}
+
+// deepCopy returns a copy of 'v' sharing no pointer, slice or map with it.
+// Shared references and cycles are preserved in the copy. Unexported struct
+// fields, channels and functions are copied shallowly.
+func deepCopy(v reflect.Value, visited map[copyKey]reflect.Value) reflect.Value {
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		key := copyKey{v.Pointer(), t, 0}
+		if c, ok := visited[key]; ok {
+			return c
+		}
+		c := reflect.New(t.Elem())
+		visited[key] = c
+		c.Elem().Set(deepCopy(v.Elem(), visited))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		key := copyKey{v.Pointer(), t, v.Len()}
+		if c, ok := visited[key]; ok {
+			return c
+		}
+		c := reflect.MakeSlice(t, v.Len(), v.Len())
+		visited[key] = c
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), visited))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		key := copyKey{v.Pointer(), t, 0}
+		if c, ok := visited[key]; ok {
+			return c
+		}
+		c := reflect.MakeMap(t)
+		visited[key] = c
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(k, deepCopy(v.MapIndex(k), visited))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(t).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), visited))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(t).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i), visited))
+			}
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(t).Elem()
+		c.Set(deepCopy(v.Elem(), visited))
+		return c
+	}
+	return v
+}
+
 func isPointerToPrimitive(v reflect.Value) bool {
 	return v.Kind() == reflect.Ptr && v.Elem().IsValid() && v.Elem().Type() != nil
 }
@@ -79,9 +181,30 @@ func luaToGoValue(L *lua.State, idx int) (reflect.Value, reflect.Type) {
 }
 
 func makeValueProxy(L *lua.State, v reflect.Value, proxyMT string) {
+	if proxyMT == cStructMeta {
+		proxyMT = structMeta(L, v.Type())
+	}
+
 	// The metatable needs be set up in the Lua state before the proxy is created,
 	// otherwise closing the state will fail on calling the garbage collector. Not
 	// really sure why this happens though...
+	pushProxyMeta(L, proxyMT)
+	L.Pop(1)
+
+	proxymu.Lock()
+	id := proxyIdCounter
+	proxyIdCounter++
+	proxyMap[id] = &valueProxy{ v: v, t: v.Type() }
+	proxymu.Unlock()
+
+	rawptr := L.NewUserdata(reflect.TypeOf(id).Size())
+	*(*uintptr)(rawptr) = id
+	L.LGetMetaTable(proxyMT)
+	L.SetMetaTable(-2)
+}
+
+// pushProxyMeta pushes the metatable 'proxyMT', creating it if need be.
+func pushProxyMeta(L *lua.State, proxyMT string) {
 	L.LGetMetaTable(proxyMT)
 	if L.IsNil(-1) {
 		flagValue := func() {
@@ -97,6 +220,7 @@ func makeValueProxy(L *lua.State, v reflect.Value, proxyMT string) {
 			L.NewMetaTable(proxyMT)
 			L.SetMetaMethod("__index", interface__index)
 			L.SetMetaMethod("__lt", number__lt)
+			L.SetMetaMethod("__le", number__le)
 			L.SetMetaMethod("__add", number__add)
 			L.SetMetaMethod("__sub", number__sub)
 			L.SetMetaMethod("__mul", number__mul)
@@ -104,6 +228,15 @@ func makeValueProxy(L *lua.State, v reflect.Value, proxyMT string) {
 			L.SetMetaMethod("__mod", number__mod)
 			L.SetMetaMethod("__pow", number__pow)
 			L.SetMetaMethod("__unm", number__unm)
+			L.SetMetaMethod("__concat", proxy__concat)
+			if luaHasInteger {
+				L.SetMetaMethod("__band", number__band)
+				L.SetMetaMethod("__bor", number__bor)
+				L.SetMetaMethod("__bxor", number__bxor)
+				L.SetMetaMethod("__bnot", number__bnot)
+				L.SetMetaMethod("__shl", number__shl)
+				L.SetMetaMethod("__shr", number__shr)
+			}
 			flagValue()
 		case cComplexMeta:
 			L.NewMetaTable(proxyMT)
@@ -144,37 +277,89 @@ func makeValueProxy(L *lua.State, v reflect.Value, proxyMT string) {
 			L.NewMetaTable(proxyMT)
 			L.SetMetaMethod("__index", struct__index)
 			L.SetMetaMethod("__newindex", struct__newindex)
+			L.SetMetaMethod("__concat", proxy__concat)
 			flagValue()
 		case cInterfaceMeta:
 			L.NewMetaTable(proxyMT)
 			L.SetMetaMethod("__index", interface__index)
+			L.SetMetaMethod("__concat", proxy__concat)
 			flagValue()
 		case cChannelMeta:
 			L.NewMetaTable(proxyMT)
 			L.SetMetaMethod("__index", channel__index)
 			flagValue()
 		}
+		L.Pop(1)
+		L.LGetMetaTable(proxyMT)
 	}
+}
 
-	proxymu.Lock()
-	id := proxyIdCounter
-	proxyIdCounter++
-	proxyMap[id] = &valueProxy{ v: v, t: v.Type() }
-	proxymu.Unlock()
+// typeMetaKey identifies a struct type registered with RegisterType in a state,
+// which is told by the address of its registry.
+type typeMetaKey struct {
+	state uintptr
+	t     reflect.Type
+}
 
-	L.Pop(1)
-	rawptr := L.NewUserdata(reflect.TypeOf(id).Size())
-	*(*uintptr)(rawptr) = id
-	L.LGetMetaTable(proxyMT)
-	L.SetMetaTable(-2)
+var (
+	typeMetas   = map[typeMetaKey]string{}
+	typeMetasMu sync.RWMutex
+	// typeMetasLen is the number of registered types, read without the lock so
+	// that the proxies of unregistered types do not contend on it.
+	typeMetasLen int32
+)
+
+// structMeta returns the name of the metatable of the proxies to the struct
+// type 't' or '*t': the one installed by registerTypeMeta, or cStructMeta.
+func structMeta(L *lua.State, t reflect.Type) string {
+	if atomic.LoadInt32(&typeMetasLen) == 0 {
+		return cStructMeta
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	typeMetasMu.RLock()
+	name, ok := typeMetas[typeMetaKey{state: L.ToPointer(lua.LUA_REGISTRYINDEX), t: t}]
+	typeMetasMu.RUnlock()
+	if !ok {
+		return cStructMeta
+	}
+	return name
+}
+
+// registerTypeMeta installs the metatable 'name' of the proxies to the struct
+// type 't' and to '*t'. It holds the metamethods of cStructMeta, '__name' and
+// the methods of '*t', which take the proxy as their first argument.
+func registerTypeMeta(L *lua.State, name string, t reflect.Type) {
+	pushProxyMeta(L, cStructMeta)
+	L.NewMetaTable(name)
+	copyFields(L, L.GetTop()-1, L.GetTop())
+	L.PushString(name)
+	L.SetField(-2, "__name")
+	pt := reflect.PtrTo(t)
+	for i := 0; i < pt.NumMethod(); i++ {
+		m := pt.Method(i)
+		GoToLua(L, m.Func.Interface())
+		L.SetField(-2, m.Name)
+	}
+	L.Pop(2)
+
+	typeMetasMu.Lock()
+	typeMetas[typeMetaKey{state: L.ToPointer(lua.LUA_REGISTRYINDEX), t: t}] = name
+	atomic.StoreInt32(&typeMetasLen, int32(len(typeMetas)))
+	typeMetasMu.Unlock()
 }
 
 func pushGoMethod(L *lua.State, name string, v reflect.Value) {
-	method := v.MethodByName(name)
-	if !method.IsValid() {
-		t := v.Type()
-		// Could not resolve this method. Perhaps it's defined on the pointer?
-		if t.Kind() != reflect.Ptr {
+	t := v.Type()
+	if i, ok := typeMethods(t)[name]; ok {
+		pushBoundMethod(L, v.Method(i))
+		return
+	}
+
+	// Could not resolve this method. Perhaps it's defined on the pointer?
+	if t.Kind() != reflect.Ptr {
+		if i, ok := typeMethods(reflect.PtrTo(t))[name]; ok {
 			if v.CanAddr() {
 				// If we can get a pointer directly.
 				v = v.Addr()
@@ -184,14 +369,107 @@ func pushGoMethod(L *lua.State, name string, v reflect.Value) {
 				vp.Elem().Set(v)
 				v = vp
 			}
-		}
-		method = v.MethodByName(name)
-		if !method.IsValid() {
-			L.PushNil()
+			pushBoundMethod(L, v.Method(i))
 			return
 		}
 	}
-	GoToLua(L, method)
+	if m, ok := ioMethod(name, v); ok {
+		pushBoundMethod(L, m)
+		return
+	}
+	L.PushNil()
+}
+
+// pushBoundMethod pushes the method value 'm' of the proxy at index 1. The
+// receiver is already bound, so the method can be called with both dot and
+// colon notation: if the proxy is passed as an extra first argument, it is
+// dropped. This holds for variadic methods too, even if they accept the proxy
+// as an argument: 'g.Add(g)' must then be written 'g:Add(g)'.
+//
+// The method value holds the receiver, so the closure can be stored and called
+// after the proxy has been collected. The proxy is recognized by its id, which
+// unlike its address is never reused.
+func pushBoundMethod(L *lua.State, m reflect.Value) {
+	self, isProxy := proxyID(L, 1)
+	f := goToLuaFunction(L, m, errorsAsProxies)
+	t := m.Type()
+	var params []reflect.Type
+	for i := 0; i < t.NumIn(); i++ {
+		params = append(params, t.In(i))
+	}
+	if len(params) > 0 && params[0] == tstate {
+		params = params[1:]
+	}
+	// The receiver is passed as one argument more than the method takes, or,
+	// for variadic methods, at least as many as it has parameters.
+	n := len(params) + 1
+	if t.IsVariadic() {
+		n = len(params)
+	}
+	L.PushGoFunction(func(L *lua.State) int {
+		if id, ok := proxyID(L, 1); isProxy && ok && id == self && (L.GetTop() == n || t.IsVariadic() && L.GetTop() > n) {
+			L.Remove(1)
+		}
+		return f(L)
+	})
+}
+
+// bitwiseOperands returns the bits of both operands of a bitwise operation and
+// the integer type of the result. Both operands must have the same type, or one
+// of them must be a Lua number, which takes the type of the other.
+func bitwiseOperands(L *lua.State) (uint64, uint64, reflect.Type) {
+	v1, t1 := luaToGoValue(L, 1)
+	v2, t2 := luaToGoValue(L, 2)
+	t := t1
+	if t1 != t2 {
+		if isPredeclaredType(t1) {
+			t = t2
+		} else if !isPredeclaredType(t2) {
+			L.RaiseError(fmt.Sprintf("bitwise operation on mismatched types %v and %v", t1, t2))
+		}
+	}
+	if !isIntegerType(t) {
+		L.RaiseError(fmt.Sprintf("bitwise operation on non-integer type %v", t))
+	}
+	return integerBits(L, v1), integerBits(L, v2), t
+}
+
+func integerBits(L *lua.State, v reflect.Value) uint64 {
+	switch unsizedKind(v) {
+	case reflect.Int64:
+		return uint64(v.Int())
+	case reflect.Uint64:
+		return v.Uint()
+	case reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			L.RaiseError(fmt.Sprintf("number %v has no integer representation", f))
+		}
+		return uint64(int64(f))
+	}
+	L.RaiseError(fmt.Sprintf("bitwise operation on non-integer value %v", v))
+	return 0
+}
+
+func isIntegerType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// pushBitwiseValue pushes a proxy of type 't' holding 'bits', truncated to the
+// size of 't'.
+func pushBitwiseValue(L *lua.State, bits uint64, t reflect.Type) {
+	v := reflect.New(t).Elem()
+	if unsizedKind(v) == reflect.Int64 {
+		v.SetInt(int64(bits))
+	} else {
+		v.SetUint(bits)
+	}
+	makeValueProxy(L, v, cNumberMeta)
 }
 
 // pushNumberValue pushes the number resulting from an arithmetic operation.
@@ -217,21 +495,92 @@ func pushNumberValue(L *lua.State, a interface{}, t1, t2 reflect.Type) {
 	}
 }
 
-func slicer(L *lua.State, v reflect.Value, metatable string) lua.LuaGoFunction {
+// checkNumberTypes raises an error if 't1' and 't2' are distinct defined types,
+// e.g. two named int types, which cannot be mixed in the operation 'op'.
+// Predeclared types, e.g. those of Lua numbers, mix with any type.
+func checkNumberTypes(L *lua.State, op string, t1, t2 reflect.Type) {
+	if t1 != t2 && t1.PkgPath() != "" && t2.PkgPath() != "" {
+		L.RaiseError(fmt.Sprintf("%s on mismatched types %v and %v", op, t1, t2))
+	}
+}
+
+// setProxyValue replaces the Go value wrapped by the proxy at 'idx'. All Lua
+// references to the proxy see the new value.
+func setProxyValue(L *lua.State, idx int, v reflect.Value) {
+	proxyId := *(*uintptr)(L.ToUserdata(idx))
+	proxymu.Lock()
+	if p, ok := proxyMap[proxyId]; ok {
+		p.v, p.t = v, v.Type()
+	} else {
+		proxyMap[proxyId] = &valueProxy{v: v, t: v.Type()}
+	}
+	proxymu.Unlock()
+}
+
+func slicer(L *lua.State, v reflect.Value, metatable string, readOnly bool) lua.LuaGoFunction {
 	return func(L *lua.State) int {
 		L.CheckInteger(1)
 		L.CheckInteger(2)
 		i := L.ToInteger(1) - 1
 		j := L.ToInteger(2) - 1
-		if i < 0 || i >= v.Len() || i > j || j > v.Len() {
+		// As in Go, 'slice(n+1, n+1)' is the empty slice at the end.
+		if i < 0 || i > j || j > v.Len() {
 			L.RaiseError("slice bounds out of range")
 		}
 		vn := v.Slice(i, j)
 		makeValueProxy(L, vn, metatable)
+		if readOnly {
+			setReadOnly(L, -1)
+		}
 		return 1
 	}
 }
 
+// subslicer returns the 'sub' method of the slice 'v': 'sub(i, j)' returns a
+// proxy to the elements 'i' to 'j' included, with 1-based indices as in
+// 'string.sub'. 'j' defaults to the length of the slice. The result aliases
+// 'v'. The method can be called with both dot and colon notation.
+func subslicer(v reflect.Value, readOnly bool) lua.LuaGoFunction {
+	return func(L *lua.State) int {
+		if isValueProxy(L, 1) {
+			L.Remove(1)
+		}
+		i := L.CheckInteger(1)
+		j := L.OptInteger(2, v.Len())
+		if i < 1 || j > v.Len() || i > j+1 {
+			L.RaiseError("slice bounds out of range")
+		}
+		makeValueProxy(L, v.Slice(i-1, j), cSliceMeta)
+		if readOnly {
+			setReadOnly(L, -1)
+		}
+		return 1
+	}
+}
+
+// shiftBits shifts 'a' by 'n' bits to the left, or to the right if 'n' is
+// negative. Right shifts of signed types are arithmetic, as in Go.
+func shiftBits(a uint64, n int64, t reflect.Type) uint64 {
+	if n >= 0 {
+		if n >= 64 {
+			return 0
+		}
+		return a << uint(n)
+	}
+	n = -n
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n >= 64 {
+			n = 63
+		}
+		return uint64(int64(a) >> uint(n))
+	}
+	if n >= 64 {
+		return 0
+	}
+	return a >> uint(n)
+}
+
 // Shorthand for kind-switches.
 func unsizedKind(v reflect.Value) reflect.Kind {
 	switch v.Kind() {
@@ -247,6 +596,166 @@ func unsizedKind(v reflect.Value) reflect.Kind {
 	return v.Kind()
 }
 
+// proxyID returns the id of the proxy at 'idx'. It returns false if the value
+// is not a proxy.
+func proxyID(L *lua.State, idx int) (uintptr, bool) {
+	if !isValueProxy(L, idx) {
+		return 0, false
+	}
+	return *(*uintptr)(L.ToUserdata(idx)), true
+}
+
+// isReadOnly reports whether the value at 'idx' is a read-only proxy.
+func isReadOnly(L *lua.State, idx int) bool {
+	id, ok := proxyID(L, idx)
+	if !ok {
+		return false
+	}
+	proxymu.RLock()
+	p, ok := proxyMap[id]
+	proxymu.RUnlock()
+	return ok && p.readOnly
+}
+
+// setReadOnly makes the proxy at 'idx' read-only. Other values are left as is.
+func setReadOnly(L *lua.State, idx int) {
+	id, ok := proxyID(L, idx)
+	if !ok {
+		return
+	}
+	proxymu.Lock()
+	if p, ok := proxyMap[id]; ok {
+		p.readOnly = true
+	}
+	proxymu.Unlock()
+}
+
+// proxyError returns the Go error wrapped by the proxy at 'idx', if any. Unlike
+// valueOfProxy, it does not raise errors and can be called outside of Lua calls.
+func proxyError(L *lua.State, idx int) (error, bool) {
+	id, ok := proxyID(L, idx)
+	if !ok {
+		return nil, false
+	}
+	proxymu.RLock()
+	p, ok := proxyMap[id]
+	proxymu.RUnlock()
+	if !ok || !p.v.CanInterface() {
+		return nil, false
+	}
+	err, ok := p.v.Interface().(error)
+	return err, ok
+}
+
+// checkWritable raises an error if the proxy at 'idx' is read-only.
+func checkWritable(L *lua.State, idx int) {
+	if isReadOnly(L, idx) {
+		_, t := valueOfProxy(L, idx)
+		L.RaiseError(fmt.Sprintf("cannot modify read-only proxy of type %s", t))
+	}
+}
+
+// pushProxy pushes 'a' as GoToLuaProxy does, or as GoToLuaReadOnly does if
+// 'readOnly' is set.
+func pushProxy(L *lua.State, a interface{}, readOnly bool) {
+	if readOnly {
+		GoToLuaReadOnly(L, a)
+	} else {
+		GoToLuaProxy(L, a)
+	}
+}
+
+// proxyCacheKey is the registry field holding the weak table of the proxies to
+// struct pointers, indexed by their proxy id. The ids are found in proxyCache.
+const proxyCacheKey = "luar.proxies"
+
+// cacheKey identifies a struct pointer in the proxy cache of a state, which is
+// told by the address of its registry. The type is part of the key since a
+// struct and its first field share the same address.
+type cacheKey struct {
+	state uintptr
+	ptr   uintptr
+	t     reflect.Type
+}
+
+// proxyCache maps the struct pointers to the id of their proxy. It is guarded
+// by proxymu.
+var proxyCache = map[cacheKey]uintptr{}
+
+// proxyCacheIndex returns the key of the struct pointer 'vp' in the proxy cache.
+func proxyCacheIndex(L *lua.State, vp reflect.Value) cacheKey {
+	return cacheKey{state: L.ToPointer(lua.LUA_REGISTRYINDEX), ptr: vp.Pointer(), t: vp.Type()}
+}
+
+// pushProxyCache pushes the proxy cache, creating it if need be.
+func pushProxyCache(L *lua.State) {
+	L.GetField(lua.LUA_REGISTRYINDEX, proxyCacheKey)
+	if !L.IsNil(-1) {
+		return
+	}
+	L.Pop(1)
+	L.NewTable()
+	// The values are weak so that the cache does not keep the proxies alive.
+	L.NewTable()
+	L.PushString("v")
+	L.SetField(-2, "__mode")
+	L.SetMetaTable(-2)
+	L.PushValue(-1)
+	L.SetField(lua.LUA_REGISTRYINDEX, proxyCacheKey)
+}
+
+// pushCachedProxy pushes the proxy previously made for the struct pointer 'vp'
+// in this state, if it is still alive. It returns false and pushes nothing
+// otherwise.
+func pushCachedProxy(L *lua.State, vp reflect.Value) bool {
+	proxymu.RLock()
+	id, ok := proxyCache[proxyCacheIndex(L, vp)]
+	proxymu.RUnlock()
+	if !ok {
+		return false
+	}
+	pushProxyCache(L)
+	L.RawGeti(-1, int(id))
+	if L.IsNil(-1) {
+		L.Pop(2)
+		return false
+	}
+	L.Remove(-2)
+	return true
+}
+
+// cacheProxy records the proxy on top of the stack as the proxy of the struct
+// pointer 'vp'.
+func cacheProxy(L *lua.State, vp reflect.Value) {
+	id, _ := proxyID(L, -1)
+	proxymu.Lock()
+	proxyCache[proxyCacheIndex(L, vp)] = id
+	proxymu.Unlock()
+	pushProxyCache(L)
+	L.PushValue(-2)
+	L.RawSeti(-2, int(id))
+	L.Pop(1)
+}
+
+// uncacheProxy removes the proxy at 'idx' from the cache, if it is the proxy of
+// the struct pointer 'vp'. 'idx' must be positive.
+func uncacheProxy(L *lua.State, idx int, vp reflect.Value) {
+	id, _ := proxyID(L, idx)
+	key := proxyCacheIndex(L, vp)
+	proxymu.Lock()
+	cached := proxyCache[key] == id
+	if cached {
+		delete(proxyCache, key)
+	}
+	proxymu.Unlock()
+	if cached {
+		pushProxyCache(L)
+		L.PushNil()
+		L.RawSeti(-2, int(id))
+		L.Pop(1)
+	}
+}
+
 func valueOfProxy(L *lua.State, idx int) (reflect.Value, reflect.Type) {
 	proxyId := *(*uintptr)(L.ToUserdata(idx))
 
@@ -287,7 +796,11 @@ func valueToNumber(L *lua.State, v reflect.Value) float64 {
 
 func valueToString(L *lua.State, v reflect.Value) string {
 	switch unsizedKind(v) {
-	case reflect.Uint64, reflect.Int64, reflect.Float64:
+	case reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float64:
 		return fmt.Sprintf("%v", valueToNumber(L, v))
 	case reflect.String:
 		return v.String()
@@ -3,11 +3,156 @@ package luar
 // Those functions are meant to be registered in Lua to manipulate proxies.
 
 import (
+	"fmt"
+	"math"
 	"reflect"
+	"sort"
+	"sync"
 
 	"github.com/aarzilli/golua/lua"
 )
 
+const bindHelperKey = "luar.bind"
+
+// bindHelper returns a function calling 'f' with the bound arguments followed
+// by its own. Nil arguments are kept, hence the explicit counts.
+const bindHelper = `
+local unpack = table.unpack or unpack
+return function(f, ...)
+	local bound = {n = select("#", ...), ...}
+	return function(...)
+		local args = {n = bound.n + select("#", ...)}
+		for i = 1, bound.n do
+			args[i] = bound[i]
+		end
+		for i = 1, select("#", ...) do
+			args[bound.n + i] = (select(i, ...))
+		end
+		return f(unpack(args, 1, args.n))
+	end
+end
+`
+
+// Bind pushes a function calling 'fn' with the given arguments followed by its
+// own, e.g. to pass preconfigured callbacks to Go. The arguments are evaluated
+// once and kept alive by the returned function, not copied: changes to a bound
+// table are visible in later calls.
+//
+// When 'fn' is a Go function, the arguments bound to its fixed parameters are
+// converted once, here, and conversion errors are raised by 'bind'. Later
+// changes to a bound table are then not visible to the function.
+//
+// Arguments: fn (function or callable value), args...
+//
+// Returns: function
+func Bind(L *lua.State) int {
+	if !L.IsFunction(1) {
+		if !L.GetMetaField(1, "__call") {
+			L.RaiseError("bind requires a function")
+		}
+		L.Pop(1)
+	}
+	if t, ok := funcType(L, 1); ok {
+		convertBoundArgs(L, t)
+	}
+	pushLuaHelper(L, bindHelperKey, bindHelper)
+	L.Insert(1)
+	if err := L.Call(L.GetTop()-1, 1); err != nil {
+		L.RaiseError(err.Error())
+	}
+	return 1
+}
+
+// convertBoundArgs replaces the arguments from index 2 bound to the fixed
+// parameters of a Go function of type 't' by proxies to their converted values.
+// Scalars are cheap to convert and are left as they are.
+func convertBoundArgs(L *lua.State, t reflect.Type) {
+	params := make([]reflect.Type, 0, t.NumIn())
+	for i := 0; i < t.NumIn(); i++ {
+		params = append(params, t.In(i))
+	}
+	if len(params) > 0 && params[0] == tstate {
+		params = params[1:]
+	}
+	if t.IsVariadic() {
+		params = params[:len(params)-1]
+	}
+	for i, pt := range params {
+		idx := i + 2
+		if idx > L.GetTop() {
+			break
+		}
+		if !L.IsTable(idx) {
+			continue
+		}
+		val := reflect.New(pt)
+		if err := LuaToGo(L, idx, val.Interface()); err != nil {
+			L.RaiseError(fmt.Sprintf("cannot convert bound argument #%v: %v", i+1, err))
+		}
+		GoToLuaProxy(L, val.Elem().Interface())
+		L.Replace(idx)
+	}
+}
+
+// funcTypesKey is the registry field holding the weak table of the Go
+// functions pushed by GoToLua, with the ids of their types in funcTypes.
+const funcTypesKey = "luar.functypes"
+
+var (
+	funcTypesMu sync.RWMutex
+	funcTypes   []reflect.Type
+	funcTypeIds = map[reflect.Type]int{}
+)
+
+// pushFuncTypes pushes the table of function types, creating it if need be.
+func pushFuncTypes(L *lua.State) {
+	L.GetField(lua.LUA_REGISTRYINDEX, funcTypesKey)
+	if !L.IsNil(-1) {
+		return
+	}
+	L.Pop(1)
+	L.NewTable()
+	// The keys are weak so that the table does not keep the functions alive.
+	L.NewTable()
+	L.PushString("k")
+	L.SetField(-2, "__mode")
+	L.SetMetaTable(-2)
+	L.PushValue(-1)
+	L.SetField(lua.LUA_REGISTRYINDEX, funcTypesKey)
+}
+
+// recordFuncType records 't' as the type of the Go function on top of the
+// stack.
+func recordFuncType(L *lua.State, t reflect.Type) {
+	funcTypesMu.Lock()
+	id, ok := funcTypeIds[t]
+	if !ok {
+		id = len(funcTypes)
+		funcTypes = append(funcTypes, t)
+		funcTypeIds[t] = id
+	}
+	funcTypesMu.Unlock()
+	pushFuncTypes(L)
+	L.PushValue(-2)
+	L.PushInteger(int64(id))
+	L.RawSet(-3)
+	L.Pop(1)
+}
+
+// funcType returns the type recorded for the Go function at 'idx', if any.
+func funcType(L *lua.State, idx int) (reflect.Type, bool) {
+	pushFuncTypes(L)
+	L.PushValue(idx)
+	L.RawGet(-2)
+	defer L.Pop(2)
+	if L.IsNil(-1) {
+		return nil, false
+	}
+	funcTypesMu.RLock()
+	defer funcTypesMu.RUnlock()
+	return funcTypes[L.ToInteger(-1)], true
+}
+
 // Complex pushes a proxy to a Go complex on the stack.
 //
 // Arguments: real (number), imag (number)
@@ -21,6 +166,79 @@ func Complex(L *lua.State) int {
 	return 1
 }
 
+// DeepEqual pushes true if the two values are deeply equal, as reported by
+// reflect.DeepEqual. When only one of them is a proxy, the other value, e.g. a
+// table, is first converted to the type of the proxied value with LuaToGo. When
+// neither is a proxy, both are converted to interface{} values. Values that
+// cannot be converted are not equal.
+//
+// Unlike '==', it can compare a proxy with a table, or two proxies wrapping
+// distinct but equal values.
+//
+// Arguments: a, b
+//
+// Returns: boolean
+func DeepEqual(L *lua.State) int {
+	var a, b reflect.Value
+	switch {
+	case isValueProxy(L, 1) && isValueProxy(L, 2):
+		a, _ = valueOfProxy(L, 1)
+		b, _ = valueOfProxy(L, 2)
+	case isValueProxy(L, 1):
+		a, _ = valueOfProxy(L, 1)
+		b = reflect.New(a.Type())
+		if LuaToGo(L, 2, b.Interface()) != nil {
+			L.PushBoolean(false)
+			return 1
+		}
+		b = b.Elem()
+	case isValueProxy(L, 2):
+		b, _ = valueOfProxy(L, 2)
+		a = reflect.New(b.Type())
+		if LuaToGo(L, 1, a.Interface()) != nil {
+			L.PushBoolean(false)
+			return 1
+		}
+		a = a.Elem()
+	default:
+		var x, y interface{}
+		if LuaToGo(L, 1, &x) != nil || LuaToGo(L, 2, &y) != nil {
+			L.PushBoolean(false)
+			return 1
+		}
+		a, b = reflect.ValueOf(x), reflect.ValueOf(y)
+	}
+	L.PushBoolean(a.IsValid() == b.IsValid() && (!a.IsValid() || reflect.DeepEqual(a.Interface(), b.Interface())))
+	return 1
+}
+
+// ErrorValue pushes the value if it is a proxy to a Go error, e.g. an error
+// caught with 'pcall' from a function registered with RegisterWithErrors under
+// WithErrorValues, and nil otherwise.
+//
+// Argument: value
+//
+// Returns: proxy (error) or nil
+func ErrorValue(L *lua.State) int {
+	if _, ok := proxyError(L, 1); ok {
+		L.PushValue(1)
+	} else {
+		L.PushNil()
+	}
+	return 1
+}
+
+// IsNull pushes true if the value is nil or luar.null, the value standing for
+// nil in the tables converted from Go slices, maps and structs.
+//
+// Argument: value
+//
+// Returns: boolean
+func IsNull(L *lua.State) int {
+	L.PushBoolean(L.IsNoneOrNil(1) || isNullProxy(L, 1))
+	return 1
+}
+
 // MakeChan creates a 'chan interface{}' proxy and pushes it on the stack.
 //
 // Optional argument: size (number)
@@ -54,6 +272,113 @@ func MakeSlice(L *lua.State) int {
 	return 1
 }
 
+// TableToSlice converts a Lua sequence to a slice proxy.
+//
+// The element type defaults to 'interface{}'. It can be set to any Go
+// predeclared type by name, e.g. "int" or "string". Conversion stops at the
+// first nil element. Non-integer keys raise an error.
+//
+// Arguments: table (table), optional element type (string)
+//
+// Returns: proxy ([]T)
+func TableToSlice(L *lua.State) int {
+	L.CheckType(1, lua.LUA_TTABLE)
+	te := optElemType(L, 2)
+
+	L.PushNil()
+	for L.Next(1) != 0 {
+		// Do not call ToNumber on non-number keys, it would convert strings.
+		if L.Type(-2) != lua.LUA_TNUMBER {
+			L.RaiseError("table2slice: non-integer key")
+		}
+		k := L.ToNumber(-2)
+		if k < 1 || k != math.Trunc(k) {
+			L.RaiseError("table2slice: non-integer key")
+		}
+		L.Pop(1)
+	}
+
+	s := reflect.MakeSlice(reflect.SliceOf(te), 0, int(L.ObjLen(1)))
+	for i := 1; ; i++ {
+		L.RawGeti(1, i)
+		if L.IsNil(-1) {
+			L.Pop(1)
+			break
+		}
+		val := reflect.New(te)
+		err := LuaToGo(L, -1, val.Interface())
+		if err != nil {
+			L.RaiseError(fmt.Sprintf("slice requires %v value type", te))
+		}
+		s = reflect.Append(s, val.Elem())
+		L.Pop(1)
+	}
+	makeValueProxy(L, s, cSliceMeta)
+	return 1
+}
+
+// TableToMap converts a Lua table with string keys to a map proxy.
+//
+// The element type defaults to 'interface{}'. It can be set to any Go
+// predeclared type by name, e.g. "int" or "string". Non-string keys raise an
+// error.
+//
+// Arguments: table (table), optional element type (string)
+//
+// Returns: proxy (map[string]T)
+func TableToMap(L *lua.State) int {
+	L.CheckType(1, lua.LUA_TTABLE)
+	te := optElemType(L, 2)
+
+	m := reflect.MakeMap(reflect.MapOf(predeclaredTypes["string"], te))
+	L.PushNil()
+	for L.Next(1) != 0 {
+		// Do not call ToString on non-string keys, it would convert numbers.
+		if L.Type(-2) != lua.LUA_TSTRING {
+			L.RaiseError("table2map: non-string key")
+		}
+		val := reflect.New(te)
+		err := LuaToGo(L, -1, val.Interface())
+		if err != nil {
+			L.RaiseError(fmt.Sprintf("map requires %v value type", te))
+		}
+		m.SetMapIndex(reflect.ValueOf(L.ToString(-2)), val.Elem())
+		L.Pop(1)
+	}
+	makeValueProxy(L, m, cMapMeta)
+	return 1
+}
+
+// optElemType returns the predeclared type named by the optional string at
+// 'idx', or 'interface{}' if none is given.
+func optElemType(L *lua.State, idx int) reflect.Type {
+	if L.IsNoneOrNil(idx) {
+		return predeclaredTypes["interface{}"]
+	}
+	name := L.CheckString(idx)
+	t, ok := predeclaredTypes[name]
+	if !ok {
+		L.RaiseError(fmt.Sprintf("unknown element type %q", name))
+	}
+	return t
+}
+
+// checkMapProxy returns the map wrapped by the proxy at 'idx' or raises an error
+// on behalf of the function 'fname'.
+func checkMapProxy(L *lua.State, idx int, fname string) reflect.Value {
+	if isValueProxy(L, idx) {
+		v, _ := valueOfProxy(L, idx)
+		for v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Map {
+			return v
+		}
+	}
+	L.RaiseError(fname + " requires a map proxy")
+	return reflect.Value{}
+}
+
 func ipairsAux(L *lua.State) int {
 	i := L.CheckInteger(2) + 1
 	L.PushInteger(int64(i))
@@ -65,6 +390,74 @@ func ipairsAux(L *lua.State) int {
 	return 2
 }
 
+// ProxyAppend appends values to a slice proxy in place, unlike the 'append'
+// method of slice proxies which returns a new slice. All Lua references to the
+// proxy see the new elements. If the proxy wraps a settable Go slice (e.g. it
+// was passed by reference), the Go slice is updated as well.
+//
+// Arguments: proxy ([]T), values (T...)
+//
+// Returns: proxy ([]T)
+func ProxyAppend(L *lua.State) int {
+	if !isValueProxy(L, 1) {
+		L.RaiseError("append requires a slice proxy")
+	}
+	v, _ := valueOfProxy(L, 1)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice {
+		L.RaiseError("append requires a slice proxy")
+	}
+	checkWritable(L, 1)
+	newslice := appendToSlice(L, v, 2)
+	if v.CanSet() {
+		v.Set(newslice)
+	} else {
+		setProxyValue(L, 1, newslice)
+	}
+	L.PushValue(1)
+	return 1
+}
+
+// ProxyClone returns a proxy to a deep copy of the proxied value. Changes to
+// the copy do not affect the original. Non-proxy values are returned as is.
+//
+// Argument: proxy
+//
+// Returns: proxy
+func ProxyClone(L *lua.State) int {
+	if !isValueProxy(L, 1) {
+		L.PushValue(1)
+		return 1
+	}
+	v, _ := valueOfProxy(L, 1)
+	GoToLuaProxy(L, deepCopy(v, map[copyKey]reflect.Value{}))
+	return 1
+}
+
+// ProxyFields pushes a table of the Lua names of the fields of a struct proxy,
+// in declaration order. Pointers are followed. The table is empty for other
+// values.
+//
+// Argument: proxy
+//
+// Returns: names (table)
+func ProxyFields(L *lua.State) int {
+	names := []string{}
+	if isValueProxy(L, 1) {
+		_, t := valueOfProxy(L, 1)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			names = fieldNames(t)
+		}
+	}
+	GoToLua(L, names)
+	return 1
+}
+
 // ProxyIpairs implements Lua 5.2 'ipairs' functions.
 // It respects the __ipairs metamethod.
 //
@@ -116,6 +509,46 @@ func RegProxyIpairs(L *lua.State, table, name string) {
 	})
 }
 
+// ProxyKeys pushes a slice proxy of the keys of a map proxy. The order of the
+// keys is unspecified.
+//
+// Argument: proxy (map[K]V)
+//
+// Returns: proxy ([]K)
+func ProxyKeys(L *lua.State) int {
+	m := checkMapProxy(L, 1, "keys")
+	s := reflect.MakeSlice(reflect.SliceOf(m.Type().Key()), 0, m.Len())
+	for _, k := range m.MapKeys() {
+		s = reflect.Append(s, k)
+	}
+	makeValueProxy(L, s, cSliceMeta)
+	return 1
+}
+
+// ProxyMapGet pushes the value of a map proxy at 'key' and whether the key is
+// present, as the two-value form of a Go map index. This tells an absent key
+// from a key holding the zero value, which indexing the proxy does not.
+//
+// Arguments: proxy (map[K]V), key (K)
+//
+// Returns: value (V), present (boolean)
+func ProxyMapGet(L *lua.State) int {
+	m := checkMapProxy(L, 1, "mapget")
+	key := reflect.New(m.Type().Key())
+	if err := LuaToGo(L, 2, key.Interface()); err != nil {
+		L.RaiseError(fmt.Sprintf("map requires %v key", m.Type().Key()))
+	}
+	val := m.MapIndex(key.Elem())
+	if !val.IsValid() {
+		L.PushNil()
+		L.PushBoolean(false)
+		return 2
+	}
+	pushProxy(L, val, isReadOnly(L, 1))
+	L.PushBoolean(true)
+	return 2
+}
+
 // ProxyMethod pushes the proxy method on the stack.
 //
 // Argument: proxy
@@ -132,6 +565,67 @@ func ProxyMethod(L *lua.State) int {
 	return 1
 }
 
+// ProxyMethods pushes a table of the names of the methods that can be called on
+// a proxy, in lexical order. Methods with a pointer receiver are included since
+// they can be called on all proxies. The table is empty for other values.
+//
+// Argument: proxy
+//
+// Returns: names (table)
+func ProxyMethods(L *lua.State) int {
+	names := []string{}
+	if isValueProxy(L, 1) {
+		_, t := valueOfProxy(L, 1)
+		if t.Kind() != reflect.Ptr {
+			t = reflect.PtrTo(t)
+		}
+		for i := 0; i < t.NumMethod(); i++ {
+			names = append(names, t.Method(i).Name)
+		}
+	}
+	GoToLua(L, names)
+	return 1
+}
+
+// ProxyOnFinalize sets the function called with the proxy when the proxy is
+// garbage-collected, e.g. a Go function closing the file or the connection it
+// wraps. A previous function is replaced; 'nil' removes it.
+//
+// The function runs from the '__gc' metamethod: there is no guarantee on when
+// it is called, only that it is called when the state is closed at the latest.
+// Use collectgarbage() to force a collection. The function is referenced until
+// it runs, so it must not capture the proxy, otherwise the proxy is never
+// collected. Its errors are discarded.
+//
+// Arguments: proxy, function
+//
+// Returns: proxy
+func ProxyOnFinalize(L *lua.State) int {
+	id, ok := proxyID(L, 1)
+	if !ok {
+		L.RaiseError("onfinalize requires a proxy")
+	}
+	ref := 0
+	if !L.IsNoneOrNil(2) {
+		L.CheckType(2, lua.LUA_TFUNCTION)
+		L.PushValue(2)
+		ref = L.Ref(lua.LUA_REGISTRYINDEX)
+	}
+
+	proxymu.Lock()
+	old := 0
+	if p, ok := proxyMap[id]; ok {
+		old, p.finalizer = p.finalizer, ref
+	}
+	proxymu.Unlock()
+	if old != 0 {
+		L.Unref(lua.LUA_REGISTRYINDEX, old)
+	}
+
+	L.SetTop(1)
+	return 1
+}
+
 // ProxyPairs implements Lua 5.2 'pairs' functions.
 // It respects the __pairs metamethod.
 //
@@ -151,6 +645,237 @@ func ProxyPairs(L *lua.State) int {
 	return 3
 }
 
+// ProxyValues pushes a slice proxy of the values of a map proxy. The order of
+// the values is unspecified.
+//
+// Argument: proxy (map[K]V)
+//
+// Returns: proxy ([]V)
+func ProxyValues(L *lua.State) int {
+	m := checkMapProxy(L, 1, "values")
+	s := reflect.MakeSlice(reflect.SliceOf(m.Type().Elem()), 0, m.Len())
+	for _, k := range m.MapKeys() {
+		s = reflect.Append(s, m.MapIndex(k))
+	}
+	makeValueProxy(L, s, cSliceMeta)
+	return 1
+}
+
+// ProxyPairsSorted is like 'pairs' for map proxies but it yields the entries in
+// the order of their keys: numbers first, sorted numerically, then strings,
+// sorted lexically, then the other keys, sorted by their fmt.Sprint
+// representation.
+//
+// Argument: proxy (map[K]V)
+//
+// Returns: iterator (function)
+func ProxyPairsSorted(L *lua.State) int {
+	m := checkMapProxy(L, 1, "pairsSorted")
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keyLess(L, keys[i], keys[j])
+	})
+	pushMapIterator(L, m, keys, isReadOnly(L, 1))
+	return 1
+}
+
+// keyLess orders map keys for ProxyPairsSorted.
+func keyLess(L *lua.State, a, b reflect.Value) bool {
+	rank := func(v reflect.Value) int {
+		switch unsizedKind(v) {
+		case reflect.Int64, reflect.Uint64, reflect.Float64:
+			return 0
+		case reflect.String:
+			return 1
+		}
+		return 2
+	}
+
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	ra, rb := rank(a), rank(b)
+	switch {
+	case ra != rb:
+		return ra < rb
+	case ra == 0:
+		return valueToNumber(L, a) < valueToNumber(L, b)
+	case ra == 1:
+		return a.String() < b.String()
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// ProxyNew pushes a proxy to a new zero value of the type of the proxy 'proto',
+// pointers being dereferenced. It generalizes 'luar.slice' and 'luar.map' to
+// any Go type, so that scripts can create values without a registered
+// constructor.
+//
+// If 'proto' is a proxy to a reflect.Type, the value is of that type.
+//
+// - Slices are made with the optional length and capacity, which default to 0
+// and the length.
+//
+// - Maps and channels are made with the optional size.
+//
+// - Other values, e.g. structs, are allocated and the proxy wraps a pointer to
+// them, so that they can be modified and methods with pointer receivers can be
+// called.
+//
+// Arguments: proto (proxy), optional length (number), optional capacity (number)
+//
+// Returns: proxy
+func ProxyNew(L *lua.State) int {
+	if !isValueProxy(L, 1) {
+		L.RaiseError("new requires a proxy")
+	}
+	v, t := valueOfProxy(L, 1)
+	if rt, ok := v.Interface().(reflect.Type); ok {
+		t = rt
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		n := L.OptInteger(2, 0)
+		c := L.OptInteger(3, n)
+		if n < 0 || c < n {
+			L.RaiseError("invalid slice length or capacity")
+		}
+		makeValueProxy(L, reflect.MakeSlice(t, n, c), cSliceMeta)
+	case reflect.Map:
+		n := L.OptInteger(2, 0)
+		if n < 0 {
+			L.RaiseError("invalid map size")
+		}
+		makeValueProxy(L, reflect.MakeMapWithSize(t, n), cMapMeta)
+	case reflect.Chan:
+		n := L.OptInteger(2, 0)
+		if n < 0 {
+			L.RaiseError("invalid channel size")
+		}
+		makeValueProxy(L, reflect.MakeChan(t, n), cChannelMeta)
+	default:
+		GoToLuaProxy(L, reflect.New(t))
+	}
+	return 1
+}
+
+const (
+	goMetaKey    = "luar.gometa"
+	goIndexKey   = "luar.goindex"
+	userIndexKey = "luar.userindex"
+)
+
+// ProxySetMeta attaches the Lua metatable 'mt' to a single proxy, e.g. to
+// define operators in Lua. Other proxies of the same type are not affected.
+//
+// The metamethods of 'mt' replace those of the proxy, except '__index' which is
+// tried first: if it gives nil, the fields and methods of the Go value are
+// looked up. '__gc' cannot be replaced. Later changes to 'mt' are not seen, and
+// calling ProxySetMeta again replaces the previous metatable.
+//
+// Arguments: proxy, mt (table)
+//
+// Returns: proxy
+func ProxySetMeta(L *lua.State) int {
+	if !isValueProxy(L, 1) {
+		L.RaiseError("setmeta requires a proxy")
+	}
+	L.CheckType(2, lua.LUA_TTABLE)
+	L.SetTop(2)
+
+	// Find the metatable of the proxy type, in case it was already replaced.
+	L.GetMetaTable(1)
+	L.GetField(3, goMetaKey)
+	if L.IsNil(4) {
+		L.Pop(1)
+		L.PushValue(3)
+	}
+
+	L.NewTable()
+	copyFields(L, 4, 5)
+	L.PushValue(4)
+	L.SetField(5, goMetaKey)
+	L.GetField(4, "__index")
+	L.SetField(5, goIndexKey)
+
+	L.PushNil()
+	for L.Next(2) != 0 {
+		if L.Type(-2) == lua.LUA_TSTRING {
+			switch L.ToString(-2) {
+			case "__gc", "luago.value":
+				L.Pop(1)
+				continue
+			case "__index":
+				L.SetField(5, userIndexKey)
+				L.PushGoFunction(chained__index)
+				L.SetField(5, "__index")
+				continue
+			}
+		}
+		L.PushValue(-2)
+		L.Insert(-2)
+		L.SetTable(5)
+	}
+
+	L.PushValue(5)
+	L.SetMetaTable(1)
+	L.SetTop(1)
+	return 1
+}
+
+// copyFields copies the fields of the table at index 'from' to the table at
+// index 'to'. Both indices must be absolute.
+func copyFields(L *lua.State, from, to int) {
+	L.PushNil()
+	for L.Next(from) != 0 {
+		L.PushValue(-2)
+		L.Insert(-2)
+		L.SetTable(to)
+	}
+}
+
+// chained__index looks up the key with the '__index' set by ProxySetMeta, then
+// with the one of the proxy.
+func chained__index(L *lua.State) int {
+	L.SetTop(2)
+	L.GetMetaTable(1)
+	L.GetField(3, userIndexKey)
+	indexWith(L, 4)
+	if !L.IsNil(-1) {
+		return 1
+	}
+	L.Pop(1)
+	L.GetField(3, goIndexKey)
+	indexWith(L, 5)
+	return 1
+}
+
+// indexWith pushes the value of the key at index 2 of the object at index 1, as
+// given by the '__index' metamethod 'h'.
+func indexWith(L *lua.State, h int) {
+	switch {
+	case L.IsFunction(h):
+		L.PushValue(h)
+		L.PushValue(1)
+		L.PushValue(2)
+		if err := L.Call(2, 1); err != nil {
+			L.RaiseError(err.Error())
+		}
+	case L.IsNil(h):
+		L.PushNil()
+	default:
+		L.PushValue(2)
+		L.GetTable(h)
+	}
+}
+
 // ProxyType pushes the proxy type on the stack.
 //
 // It behaves like Lua's "type" except for proxies for which it returns
@@ -186,6 +911,186 @@ func ProxyType(L *lua.State) int {
 	return 1
 }
 
+// ProxyTypeOf describes the Go type of a proxy with a table:
+//
+//	kind: the kind of the type after dereferencing pointers, e.g. "struct"
+//	name: the name of the dereferenced type, e.g. "person", if it has one
+//	string: the full type, e.g. "*luar.person"
+//	fields: the Lua names of the struct fields, in declaration order
+//	methods: the names of the methods, in lexical order
+//
+// For other values, only 'kind' is set to the Lua type.
+//
+// Argument: value
+//
+// Returns: description (table)
+func ProxyTypeOf(L *lua.State) int {
+	if !isValueProxy(L, 1) {
+		GoToLua(L, Map{"kind": L.LTypename(1)})
+		return 1
+	}
+	_, t := valueOfProxy(L, 1)
+	desc := Map{"string": t.String()}
+
+	methods := make([]string, 0, t.NumMethod())
+	for i := 0; i < t.NumMethod(); i++ {
+		methods = append(methods, t.Method(i).Name)
+	}
+	desc["methods"] = methods
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	desc["kind"] = t.Kind().String()
+	if t.Name() != "" {
+		desc["name"] = t.Name()
+	}
+	if t.Kind() == reflect.Struct {
+		desc["fields"] = fieldNames(t)
+	}
+
+	GoToLua(L, desc)
+	return 1
+}
+
+// fieldNames returns the Lua names of the fields of the struct type 't', in
+// declaration order.
+func fieldNames(t reflect.Type) []string {
+	fields := structFields(t)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return indexLess(fields[names[i]], fields[names[j]])
+	})
+	return names
+}
+
+// Raw pushes the plain Lua value of a proxy: proxies to numbers and strings give
+// Lua numbers and strings, proxies to slices, arrays, maps and structs give
+// tables as with Unproxify, and luar.null gives nil. Other values are pushed
+// unchanged.
+//
+// Argument: value
+//
+// Returns: value (Lua value)
+func Raw(L *lua.State) int {
+	if !isValueProxy(L, 1) {
+		L.SetTop(1)
+		return 1
+	}
+	if isNullProxy(L, 1) {
+		L.PushNil()
+		return 1
+	}
+	return Unproxify(L)
+}
+
+// Select runs a select statement on channel proxies, see reflect.Select. The
+// cases are tables of the following forms:
+//
+//	{chan=c}: receive from 'c'.
+//	{chan=c, send=v}: send 'v' to 'c'. Send luar.null to send nil.
+//	{default=true}: the default case, chosen if no other case is ready.
+//
+// Argument: cases (table)
+//
+// Returns: index (number), value, ok (boolean)
+//
+// 'index' is the position of the chosen case in 'cases'. For a receive case,
+// 'value' and 'ok' are the results of the receive operation.
+func Select(L *lua.State) int {
+	L.CheckType(1, lua.LUA_TTABLE)
+	n := int(L.ObjLen(1))
+	cases := make([]reflect.SelectCase, n)
+	for i := range cases {
+		L.RawGeti(1, i+1)
+		if !L.IsTable(-1) {
+			L.RaiseError(fmt.Sprintf("select case #%d is not a table", i+1))
+		}
+
+		L.GetField(-1, "default")
+		isDefault := L.ToBoolean(-1)
+		L.Pop(1)
+		if isDefault {
+			cases[i].Dir = reflect.SelectDefault
+			L.Pop(1)
+			continue
+		}
+
+		L.GetField(-1, "chan")
+		if !isValueProxy(L, -1) {
+			L.RaiseError(fmt.Sprintf("select case #%d has no channel", i+1))
+		}
+		ch, _ := valueOfProxy(L, -1)
+		for ch.Kind() == reflect.Ptr {
+			ch = ch.Elem()
+		}
+		if ch.Kind() != reflect.Chan {
+			L.RaiseError(fmt.Sprintf("select case #%d has no channel", i+1))
+		}
+		cases[i].Chan = ch
+		L.Pop(1)
+
+		L.GetField(-1, "send")
+		if L.IsNil(-1) {
+			cases[i].Dir = reflect.SelectRecv
+		} else {
+			val := reflect.New(ch.Type().Elem())
+			err := LuaToGo(L, -1, val.Interface())
+			if err != nil {
+				L.RaiseError(fmt.Sprintf("channel requires %v value type", ch.Type().Elem()))
+			}
+			cases[i].Dir = reflect.SelectSend
+			cases[i].Send = val.Elem()
+		}
+		L.Pop(2)
+	}
+
+	// Sending on a closed channel panics, as do several default cases.
+	defer raiseGoPanic(L)
+	chosen, recv, recvOK := reflect.Select(cases)
+
+	L.PushInteger(int64(chosen + 1))
+	if cases[chosen].Dir != reflect.SelectRecv {
+		return 1
+	}
+	if recvOK {
+		GoToLuaProxy(L, recv)
+	} else {
+		L.PushNil()
+	}
+	L.PushBoolean(recvOK)
+	return 3
+}
+
+// ToString pushes a string describing the value, as a single entry point for
+// display:
+//
+//   - the result of String() for proxies implementing fmt.Stringer;
+//   - the result of Error() for proxies implementing error;
+//   - 'type@address', e.g. "main.person@0xc000010030", for the other proxies to
+//     structs;
+//   - the value formatted by fmt.Sprint for the other proxies;
+//   - the result of 'tostring' for Lua values.
+//
+// Argument: value
+//
+// Returns: string
+func ToString(L *lua.State) int {
+	if !isValueProxy(L, 1) {
+		if L.CallMeta(1, "__tostring") == 0 {
+			L.PushString(luaToString(L, 1))
+		}
+		return 1
+	}
+
+	v, _ := valueOfProxy(L, 1)
+	L.PushString(proxyString(v))
+	return 1
+}
+
 // Unproxify converts a proxy to an unproxified Lua value.
 //
 // Argument: proxy
@@ -13,6 +13,7 @@ import (
 	"math"
 	"math/cmplx"
 	"reflect"
+	"unsafe"
 
 	"github.com/aarzilli/golua/lua"
 )
@@ -31,6 +32,18 @@ func channel__index(L *lua.State) int {
 			return 0
 		}
 		L.PushGoFunction(f)
+	case "receive":
+		f := func(L *lua.State) int {
+			val, ok := v.Recv()
+			if ok {
+				GoToLuaProxy(L, val)
+			} else {
+				L.PushNil()
+			}
+			L.PushBoolean(ok)
+			return 2
+		}
+		L.PushGoFunction(f)
 	case "send":
 		f := func(L *lua.State) int {
 			val := reflect.New(t.Elem())
@@ -38,12 +51,15 @@ func channel__index(L *lua.State) int {
 			if err != nil {
 				L.RaiseError(fmt.Sprintf("channel requires %v value type", t.Elem()))
 			}
+			// Sending on a closed channel panics.
+			defer raiseGoPanic(L)
 			v.Send(val.Elem())
 			return 0
 		}
 		L.PushGoFunction(f)
 	case "close":
 		f := func(L *lua.State) int {
+			defer raiseGoPanic(L)
 			v.Close()
 			return 0
 		}
@@ -58,9 +74,9 @@ func complex__index(L *lua.State) int {
 	v, _ := valueOfProxy(L, 1)
 	name := L.ToString(2)
 	switch name {
-	case "real":
+	case "real", "re":
 		L.PushNumber(real(v.Complex()))
-	case "imag":
+	case "imag", "im":
 		L.PushNumber(imag(v.Complex()))
 	default:
 		pushGoMethod(L, name, v)
@@ -84,7 +100,7 @@ func map__index(L *lua.State) int {
 		key = key.Elem()
 		val := v.MapIndex(key)
 		if val.IsValid() {
-			GoToLuaProxy(L, val)
+			pushProxy(L, val, isReadOnly(L, 1))
 			return 1
 		}
 	}
@@ -120,6 +136,7 @@ func map__ipairs(L *lua.State) int {
 		}
 	}
 
+	readOnly := isReadOnly(L, 1)
 	idx := uint64(0)
 	iter := func(L *lua.State) int {
 		idx++
@@ -128,7 +145,7 @@ func map__ipairs(L *lua.State) int {
 		}
 		GoToLuaProxy(L, idx)
 		val := v.MapIndex(intKeys[idx])
-		GoToLuaProxy(L, val)
+		pushProxy(L, val, readOnly)
 		return 2
 	}
 	L.PushGoFunction(iter)
@@ -136,6 +153,7 @@ func map__ipairs(L *lua.State) int {
 }
 
 func map__newindex(L *lua.State) int {
+	checkWritable(L, 1)
 	v, t := valueOfProxy(L, 1)
 	key := reflect.New(t.Key())
 	err := LuaToGo(L, 2, key.Interface())
@@ -155,26 +173,40 @@ func map__newindex(L *lua.State) int {
 
 func map__pairs(L *lua.State) int {
 	v, _ := valueOfProxy(L, 1)
-	keys := v.MapKeys()
+	// Snapshot the keys so that the map can be modified while iterating.
+	pushMapIterator(L, v, v.MapKeys(), isReadOnly(L, 1))
+	return 1
+}
+
+// pushMapIterator pushes an iterator over the entries of the map 'v' in the
+// order of 'keys'. The entries deleted during the iteration are skipped. The
+// values are pushed as read-only proxies if 'readOnly' is set.
+func pushMapIterator(L *lua.State, v reflect.Value, keys []reflect.Value, readOnly bool) {
 	idx := -1
-	n := v.Len()
+	n := len(keys)
 	iter := func(L *lua.State) int {
-		idx++
-		if idx == n {
-			return 0
+		for {
+			idx++
+			if idx >= n {
+				return 0
+			}
+			val := v.MapIndex(keys[idx])
+			if !val.IsValid() {
+				// Skip the entries that were deleted since the iteration started.
+				continue
+			}
+			GoToLuaProxy(L, keys[idx])
+			pushProxy(L, val, readOnly)
+			return 2
 		}
-		GoToLuaProxy(L, keys[idx])
-		val := v.MapIndex(keys[idx])
-		GoToLuaProxy(L, val)
-		return 2
 	}
 	L.PushGoFunction(iter)
-	return 1
 }
 
 func number__add(L *lua.State) int {
 	v1, t1 := luaToGoValue(L, 1)
 	v2, t2 := luaToGoValue(L, 2)
+	checkNumberTypes(L, "arithmetic", t1, t2)
 	var result interface{}
 	switch commonKind(v1, v2) {
 	case reflect.Uint64:
@@ -190,9 +222,37 @@ func number__add(L *lua.State) int {
 	return 1
 }
 
+func number__band(L *lua.State) int {
+	a, b, t := bitwiseOperands(L)
+	pushBitwiseValue(L, a&b, t)
+	return 1
+}
+
+func number__bnot(L *lua.State) int {
+	v, t := luaToGoValue(L, 1)
+	if !isIntegerType(t) {
+		L.RaiseError(fmt.Sprintf("bitwise operation on non-integer type %v", t))
+	}
+	pushBitwiseValue(L, ^integerBits(L, v), t)
+	return 1
+}
+
+func number__bor(L *lua.State) int {
+	a, b, t := bitwiseOperands(L)
+	pushBitwiseValue(L, a|b, t)
+	return 1
+}
+
+func number__bxor(L *lua.State) int {
+	a, b, t := bitwiseOperands(L)
+	pushBitwiseValue(L, a^b, t)
+	return 1
+}
+
 func number__div(L *lua.State) int {
 	v1, t1 := luaToGoValue(L, 1)
 	v2, t2 := luaToGoValue(L, 2)
+	checkNumberTypes(L, "arithmetic", t1, t2)
 	var result interface{}
 	switch commonKind(v1, v2) {
 	case reflect.Uint64:
@@ -208,9 +268,25 @@ func number__div(L *lua.State) int {
 	return 1
 }
 
+func number__le(L *lua.State) int {
+	v1, t1 := luaToGoValue(L, 1)
+	v2, t2 := luaToGoValue(L, 2)
+	checkNumberTypes(L, "comparison", t1, t2)
+	switch commonKind(v1, v2) {
+	case reflect.Uint64:
+		L.PushBoolean(v1.Uint() <= v2.Uint())
+	case reflect.Int64:
+		L.PushBoolean(v1.Int() <= v2.Int())
+	case reflect.Float64:
+		L.PushBoolean(valueToNumber(L, v1) <= valueToNumber(L, v2))
+	}
+	return 1
+}
+
 func number__lt(L *lua.State) int {
-	v1, _ := luaToGoValue(L, 1)
-	v2, _ := luaToGoValue(L, 2)
+	v1, t1 := luaToGoValue(L, 1)
+	v2, t2 := luaToGoValue(L, 2)
+	checkNumberTypes(L, "comparison", t1, t2)
 	switch commonKind(v1, v2) {
 	case reflect.Uint64:
 		L.PushBoolean(v1.Uint() < v2.Uint())
@@ -225,6 +301,7 @@ func number__lt(L *lua.State) int {
 func number__mod(L *lua.State) int {
 	v1, t1 := luaToGoValue(L, 1)
 	v2, t2 := luaToGoValue(L, 2)
+	checkNumberTypes(L, "arithmetic", t1, t2)
 	var result interface{}
 	switch commonKind(v1, v2) {
 	case reflect.Uint64:
@@ -241,6 +318,7 @@ func number__mod(L *lua.State) int {
 func number__mul(L *lua.State) int {
 	v1, t1 := luaToGoValue(L, 1)
 	v2, t2 := luaToGoValue(L, 2)
+	checkNumberTypes(L, "arithmetic", t1, t2)
 	var result interface{}
 	switch commonKind(v1, v2) {
 	case reflect.Uint64:
@@ -259,6 +337,7 @@ func number__mul(L *lua.State) int {
 func number__pow(L *lua.State) int {
 	v1, t1 := luaToGoValue(L, 1)
 	v2, t2 := luaToGoValue(L, 2)
+	checkNumberTypes(L, "arithmetic", t1, t2)
 	var result interface{}
 	switch commonKind(v1, v2) {
 	case reflect.Uint64:
@@ -274,9 +353,22 @@ func number__pow(L *lua.State) int {
 	return 1
 }
 
+func number__shl(L *lua.State) int {
+	a, n, t := bitwiseOperands(L)
+	pushBitwiseValue(L, shiftBits(a, int64(n), t), t)
+	return 1
+}
+
+func number__shr(L *lua.State) int {
+	a, n, t := bitwiseOperands(L)
+	pushBitwiseValue(L, shiftBits(a, -int64(n), t), t)
+	return 1
+}
+
 func number__sub(L *lua.State) int {
 	v1, t1 := luaToGoValue(L, 1)
 	v2, t2 := luaToGoValue(L, 2)
+	checkNumberTypes(L, "arithmetic", t1, t2)
 	var result interface{}
 	switch commonKind(v1, v2) {
 	case reflect.Uint64:
@@ -319,31 +411,164 @@ func number__unm(L *lua.State) int {
 // From Lua's specs: "A metamethod only is selected when both objects being
 // compared have the same type and the same metamethod for the selected
 // operation." Thus both arguments must be proxies for this function to be
-// called. No need to check for type equality: Go's "==" operator will do it for
-// us.
+// called.
+//
+// Pointers to structs compare the structs they point to. Values of
+// non-comparable types fall back to identity.
 func proxy__eq(L *lua.State) int {
-	var a1 interface{}
-	_ = LuaToGo(L, 1, &a1)
-	var a2 interface{}
-	_ = LuaToGo(L, 2, &a2)
-	L.PushBoolean(a1 == a2)
+	v1, t1 := valueOfProxy(L, 1)
+	v2, t2 := valueOfProxy(L, 2)
+	if t1 != t2 {
+		L.PushBoolean(false)
+		return 1
+	}
+	L.PushBoolean(valueEqual(v1, v2))
 	return 1
 }
 
+func valueEqual(v1, v2 reflect.Value) (equal bool) {
+	if v1.Kind() == reflect.Ptr && v1.Type().Elem().Kind() == reflect.Struct {
+		if v1.Pointer() == v2.Pointer() {
+			return true
+		}
+		if v1.IsNil() || v2.IsNil() {
+			return false
+		}
+		v1, v2 = v1.Elem(), v2.Elem()
+	}
+
+	switch v1.Kind() {
+	case reflect.Slice:
+		return v1.Pointer() == v2.Pointer() && v1.Len() == v2.Len()
+	case reflect.Map, reflect.Func:
+		return v1.Pointer() == v2.Pointer()
+	}
+	if !v1.Type().Comparable() {
+		return false
+	}
+
+	// Comparable structs may still hold non-comparable values in interface
+	// fields, in which case '==' panics.
+	defer func() {
+		if recover() != nil {
+			equal = false
+		}
+	}()
+	return v1.Interface() == v2.Interface()
+}
+
 func proxy__gc(L *lua.State) int {
 	proxyId := *(*uintptr)(L.ToUserdata(1))
+	proxymu.RLock()
+	var ref int
+	var v reflect.Value
+	if p, ok := proxyMap[proxyId]; ok {
+		ref, v = p.finalizer, p.v
+	}
+	proxymu.RUnlock()
+	if ref != 0 {
+		// The proxy is still valid while its finalizer runs. Errors cannot be
+		// reported from the collector and are discarded.
+		L.RawGeti(lua.LUA_REGISTRYINDEX, ref)
+		L.PushValue(1)
+		L.Call(1, 0)
+		L.Unref(lua.LUA_REGISTRYINDEX, ref)
+	}
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+		uncacheProxy(L, 1, v)
+	}
 	proxymu.Lock()
 	delete(proxyMap, proxyId)
 	proxymu.Unlock()
 	return 0
 }
 
+// proxy__concat concatenates a proxy implementing fmt.Stringer or error with a
+// string, a number or another proxy.
+func proxy__concat(L *lua.State) int {
+	L.PushString(concatOperand(L, 1) + concatOperand(L, 2))
+	return 1
+}
+
+func concatOperand(L *lua.State, idx int) string {
+	if isValueProxy(L, idx) {
+		v, _ := valueOfProxy(L, idx)
+		if s, ok := stringerOf(v); ok {
+			return s.String()
+		}
+		if v.CanInterface() {
+			if err, ok := v.Interface().(error); ok {
+				return err.Error()
+			}
+		}
+		if s, ok := structString(v); ok {
+			return s
+		}
+		return valueToString(L, v)
+	}
+	if L.IsString(idx) {
+		return L.ToString(idx)
+	}
+	L.RaiseError(fmt.Sprintf("attempt to concatenate a %s value", L.LTypename(idx)))
+	return ""
+}
+
+// stringerOf returns the fmt.Stringer implemented by 'v' or by its address.
+func stringerOf(v reflect.Value) (fmt.Stringer, bool) {
+	if !v.CanInterface() {
+		return nil, false
+	}
+	if v.Type().Implements(tstringer) {
+		return v.Interface().(fmt.Stringer), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(tstringer) {
+		return v.Addr().Interface().(fmt.Stringer), true
+	}
+	return nil, false
+}
+
 func proxy__tostring(L *lua.State) int {
 	v, _ := valueOfProxy(L, 1)
-	L.PushString(fmt.Sprintf("%v", v))
+	L.PushString(proxyString(v))
 	return 1
 }
 
+// proxyString returns the string form of the proxied value 'v', see ToString.
+func proxyString(v reflect.Value) string {
+	if s, ok := stringerOf(v); ok {
+		// Let fmt handle nil receivers and panics.
+		return fmt.Sprint(s)
+	}
+	if v.CanInterface() {
+		if err, ok := v.Interface().(error); ok {
+			return fmt.Sprint(err)
+		}
+	}
+	if s, ok := structString(v); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// structString returns 'type@address' for a struct or a pointer to a struct, so
+// that the fields are not dumped. Structs without address give their type only.
+func structString(v reflect.Value) (string, bool) {
+	e := v
+	for e.Kind() == reflect.Ptr && !e.IsNil() {
+		e = e.Elem()
+	}
+	if e.Kind() != reflect.Struct {
+		return "", false
+	}
+	switch {
+	case v.Kind() == reflect.Ptr:
+		return fmt.Sprintf("%v@%#x", e.Type(), v.Pointer()), true
+	case v.CanAddr():
+		return fmt.Sprintf("%v@%#x", e.Type(), v.Addr().Pointer()), true
+	}
+	return e.Type().String(), true
+}
+
 func slice__index(L *lua.State) int {
 	v, _ := valueOfProxy(L, 1)
 	for v.Kind() == reflect.Ptr {
@@ -351,12 +576,12 @@ func slice__index(L *lua.State) int {
 		v = v.Elem()
 	}
 	if L.IsNumber(2) {
-		idx := L.ToInteger(2)
+		idx := checkSliceIndex(L)
 		if idx < 1 || idx > v.Len() {
 			L.RaiseError("slice/array get: index out of range")
 		}
 		v := v.Index(idx - 1)
-		GoToLuaProxy(L, v)
+		pushProxy(L, v, isReadOnly(L, 1))
 
 	} else if L.IsString(2) {
 		name := L.ToString(2)
@@ -364,28 +589,25 @@ func slice__index(L *lua.State) int {
 			pushGoMethod(L, name, v)
 			return 1
 		}
+		readOnly := isReadOnly(L, 1)
 		switch name {
 		case "append":
 			f := func(L *lua.State) int {
-				narg := L.GetTop()
-				args := []reflect.Value{}
-				for i := 1; i <= narg; i++ {
-					elem := reflect.New(v.Type().Elem())
-					err := LuaToGo(L, i, elem.Interface())
-					if err != nil {
-						L.RaiseError(fmt.Sprintf("slice requires %v value type", v.Type().Elem()))
-					}
-					args = append(args, elem.Elem())
-				}
-				newslice := reflect.Append(v, args...)
+				newslice := appendToSlice(L, v, 1)
 				makeValueProxy(L, newslice, cSliceMeta)
+				if readOnly {
+					// The new slice may share its elements with 'v'.
+					setReadOnly(L, -1)
+				}
 				return 1
 			}
 			L.PushGoFunction(f)
 		case "cap":
 			L.PushInteger(int64(v.Cap()))
 		case "slice":
-			L.PushGoFunction(slicer(L, v, cSliceMeta))
+			L.PushGoFunction(slicer(L, v, cSliceMeta, readOnly))
+		case "sub":
+			L.PushGoFunction(subslicer(v, readOnly))
 		default:
 			pushGoMethod(L, name, v)
 		}
@@ -396,34 +618,36 @@ func slice__index(L *lua.State) int {
 }
 
 func slice__ipairs(L *lua.State) int {
-	v, _ := valueOfProxy(L, 1)
-	for v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	n := v.Len()
-	idx := -1
+	// The iterator is stateless, like Lua's own ipairs: the proxied value is read
+	// at every step so that the loop sees the slice growing or being replaced.
 	iter := func(L *lua.State) int {
-		idx++
-		if idx == n {
+		s, _ := valueOfProxy(L, 1)
+		for s.Kind() == reflect.Ptr {
+			s = s.Elem()
+		}
+		idx := L.ToInteger(2)
+		if idx < 0 || idx >= s.Len() {
 			return 0
 		}
 		GoToLuaProxy(L, idx+1) // report as 1-based index
-		val := v.Index(idx)
-		GoToLuaProxy(L, val)
+		pushProxy(L, s.Index(idx), isReadOnly(L, 1))
 		return 2
 	}
 	L.PushGoFunction(iter)
-	return 1
+	L.PushValue(1)
+	L.PushInteger(0)
+	return 3
 }
 
 func slice__newindex(L *lua.State) int {
+	checkWritable(L, 1)
 	v, t := valueOfProxy(L, 1)
 	for v.Kind() == reflect.Ptr {
 		// For arrays.
 		v = v.Elem()
 		t = t.Elem()
 	}
-	idx := L.ToInteger(2)
+	idx := checkSliceIndex(L)
 	val := reflect.New(t.Elem())
 	err := LuaToGo(L, 3, val.Interface())
 	if err != nil {
@@ -437,6 +661,20 @@ func slice__newindex(L *lua.State) int {
 	return 0
 }
 
+// checkSliceIndex returns the index at position 2 of a slice or array
+// metamethod. Indices start from 1 as for Lua sequences. Fractional indices
+// raise an error rather than being truncated.
+func checkSliceIndex(L *lua.State) int {
+	if !L.IsNumber(2) {
+		L.RaiseError("non-integer slice/array index")
+	}
+	idx := L.ToInteger(2)
+	if float64(idx) != L.ToNumber(2) {
+		L.RaiseError("non-integer slice/array index")
+	}
+	return idx
+}
+
 func slicemap__len(L *lua.State) int {
 	v, _ := valueOfProxy(L, 1)
 	for v.Kind() == reflect.Ptr {
@@ -480,7 +718,7 @@ func string__index(L *lua.State) int {
 	} else if L.IsString(2) {
 		name := L.ToString(2)
 		if name == "slice" {
-			L.PushGoFunction(slicer(L, v, cStringMeta))
+			L.PushGoFunction(slicer(L, v, cStringMeta, false))
 		} else {
 			pushGoMethod(L, name, v)
 		}
@@ -531,26 +769,61 @@ func struct__index(L *lua.State) int {
 	if t.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
-	field := v.FieldByName(name)
-	if !field.IsValid() || !field.CanSet() {
+	index, ok := structFields(v.Type())[name]
+	if ok {
+		f, ok := fieldByIndex(v, index, false)
+		if !ok {
+			L.RaiseError(fmt.Sprintf("field `%s` of type %s is embedded through a nil pointer", name, v.Type()))
+		}
+		pushProxy(L, f, isReadOnly(L, 1))
+	} else if f, ok := unexportedField(v, name); ok {
+		// Push a copy so that the field cannot be modified.
+		GoToLua(L, f)
+	} else {
 		// No such exported field, try for method.
 		pushGoMethod(L, name, vp)
-	} else {
-		GoToLuaProxy(L, field)
 	}
 	return 1
 }
 
+// unexportedField returns the unexported field 'name' of the struct 'v' if
+// ReadUnexportedFields is set. Only fields declared directly in the struct are
+// considered.
+func unexportedField(v reflect.Value, name string) (reflect.Value, bool) {
+	if !ReadUnexportedFields {
+		return reflect.Value{}, false
+	}
+	f, ok := v.Type().FieldByName(name)
+	if !ok || f.PkgPath == "" || len(f.Index) != 1 {
+		return reflect.Value{}, false
+	}
+	if !v.CanAddr() {
+		vc := reflect.New(v.Type()).Elem()
+		vc.Set(v)
+		v = vc
+	}
+	fv := v.Field(f.Index[0])
+	return reflect.NewAt(fv.Type(), unsafe.Pointer(fv.UnsafeAddr())).Elem(), true
+}
+
 func struct__newindex(L *lua.State) int {
+	checkWritable(L, 1)
 	v, t := valueOfProxy(L, 1)
 	name := L.ToString(2)
 	if t.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
-	field := v.FieldByName(name)
-	if !field.IsValid() {
+	index, ok := structFields(v.Type())[name]
+	if !ok {
+		if _, ok := unexportedField(v, name); ok {
+			L.RaiseError(fmt.Sprintf("field `%s` of type %s is read-only", name, v.Type()))
+		}
 		L.RaiseError(fmt.Sprintf("no field named `%s` for type %s", name, v.Type()))
 	}
+	field, ok := fieldByIndex(v, index, false)
+	if !ok {
+		L.RaiseError(fmt.Sprintf("field `%s` of type %s is embedded through a nil pointer", name, v.Type()))
+	}
 	val := reflect.New(field.Type())
 	err := LuaToGo(L, 3, val.Interface())
 	if err != nil {
@@ -0,0 +1,93 @@
+package luar
+
+import (
+	"errors"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+var (
+	// ErrInstructionLimit is returned by Sandbox.Run when the script exceeds
+	// MaxInstructions.
+	ErrInstructionLimit = errors.New("instruction limit exceeded")
+	// ErrMemoryLimit is returned by Sandbox.Run when the state exceeds
+	// MaxMemory.
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+)
+
+// sandboxCheckInterval is the maximum number of Lua instructions run between
+// two checks of the limits.
+const sandboxCheckInterval = 1000
+
+// Sandbox runs scripts within resource limits.
+//
+// The limits are checked by a Lua debug hook every few instructions, so they
+// can be slightly exceeded. In particular, a single call to a Go or a Lua
+// library function, e.g. 'string.rep', is not interrupted.
+type Sandbox struct {
+	// MaxInstructions is the number of Lua instructions a call to Run may
+	// execute. Zero means no limit.
+	MaxInstructions int
+	// MaxMemory is the number of bytes the Lua state may use, including the
+	// memory in use before Run is called. Zero means no limit.
+	MaxMemory int
+
+	l *lua.State
+}
+
+// NewSandbox returns a sandbox running scripts in 'L'. It has no limit until
+// they are set.
+func NewSandbox(L *lua.State) *Sandbox {
+	return &Sandbox{l: L}
+}
+
+// Run runs 'code' like L.DoString. It returns ErrInstructionLimit or
+// ErrMemoryLimit if the script was aborted for exceeding a limit.
+//
+// The hook replaces any hook previously set on the state, e.g. by
+// SetExecutionLimit, and it is removed when Run returns. Once a limit is
+// exceeded, the error is raised again on every instruction, so that scripts
+// cannot carry on by catching it with 'pcall'.
+func (s *Sandbox) Run(code string) error {
+	L := s.l
+	if s.MaxInstructions <= 0 && s.MaxMemory <= 0 {
+		return L.DoString(code)
+	}
+
+	interval := sandboxCheckInterval
+	if s.MaxInstructions > 0 && s.MaxInstructions < interval {
+		interval = s.MaxInstructions
+	}
+
+	var limitErr error
+	var hook func(L *lua.State)
+	count := 0
+	hook = func(L *lua.State) {
+		if limitErr != nil {
+			L.RaiseError(limitErr.Error())
+		}
+		count += interval
+		if s.MaxInstructions > 0 && count >= s.MaxInstructions {
+			limitErr = ErrInstructionLimit
+		} else if s.MaxMemory > 0 && memoryInUse(L) > s.MaxMemory {
+			limitErr = ErrMemoryLimit
+		}
+		if limitErr != nil {
+			L.SetHook(hook, 1)
+			L.RaiseError(limitErr.Error())
+		}
+	}
+	L.SetHook(hook, interval)
+	defer L.SetHook(nil, 0)
+
+	err := L.DoString(code)
+	if limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+// memoryInUse returns the number of bytes used by the Lua state.
+func memoryInUse(L *lua.State) int {
+	return L.GC(lua.LUA_GCCOUNT, 0)*1024 + L.GC(lua.LUA_GCCOUNTB, 0)
+}
@@ -0,0 +1,58 @@
+package luar
+
+import (
+	"sync"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// SyncState serializes the access to a Lua state shared by several goroutines.
+//
+// Lua states are not safe for concurrent use: SyncState only ensures that one
+// goroutine at a time runs Lua code. A long-running script blocks all the other
+// goroutines until it returns.
+//
+// The state must not be used directly while it is wrapped, and neither must
+// the proxies and LuaObjects bound to it, except from within Do.
+type SyncState struct {
+	mu sync.Mutex
+	l  *lua.State
+}
+
+// NewSyncState wraps 'L' for concurrent use.
+func NewSyncState(L *lua.State) *SyncState {
+	return &SyncState{l: L}
+}
+
+// Do runs 'f' with exclusive access to the state.
+func (s *SyncState) Do(f func(L *lua.State) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return f(s.l)
+}
+
+// DoString runs 'code' with exclusive access to the state. The results are
+// discarded.
+func (s *SyncState) DoString(code string) error {
+	return s.Do(func(L *lua.State) error {
+		defer L.SetTop(L.GetTop())
+		return L.DoString(code)
+	})
+}
+
+// Call calls 'lo' with exclusive access to the state. See LuaObject.Call.
+func (s *SyncState) Call(lo *LuaObject, results interface{}, args ...interface{}) error {
+	if lo.l != s.l {
+		return ErrLuaObjectUnsharedState
+	}
+	return s.Do(func(L *lua.State) error {
+		return lo.Call(results, args...)
+	})
+}
+
+// Close closes the state once no other goroutine is using it.
+func (s *SyncState) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.l.Close()
+}
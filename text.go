@@ -0,0 +1,54 @@
+package luar
+
+import (
+	"encoding"
+	"reflect"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// TextMarshalers makes GoToLua and GoToLuaProxy convert the values implementing
+// encoding.TextMarshaler to Lua strings with MarshalText, and LuaToGo convert
+// Lua strings to the values implementing encoding.TextUnmarshaler with
+// UnmarshalText. This is handy for identifiers, enums represented as strings
+// and the like.
+//
+// It is off by default since such values then lose their methods in Lua. If
+// MarshalText fails, the value is converted as usual. If UnmarshalText fails,
+// LuaToGo returns its error.
+var TextMarshalers = false
+
+var (
+	ttextMarshaler   = typeof((*encoding.TextMarshaler)(nil))
+	ttextUnmarshaler = typeof((*encoding.TextUnmarshaler)(nil))
+)
+
+// pushText pushes the text encoding of 'v'. It returns false and pushes nothing
+// if 'v' is not an encoding.TextMarshaler or if the encoding fails.
+func pushText(L *lua.State, v reflect.Value) bool {
+	if !v.Type().Implements(ttextMarshaler) && v.CanAddr() {
+		v = v.Addr()
+	}
+	if !v.CanInterface() || !v.Type().Implements(ttextMarshaler) {
+		return false
+	}
+	text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return false
+	}
+	L.PushString(string(text))
+	return true
+}
+
+// unmarshalText sets 'v' from the Lua string at 'idx' if the address of 'v' is
+// an encoding.TextUnmarshaler. It returns false if it is not.
+func unmarshalText(L *lua.State, idx int, v reflect.Value) (bool, error) {
+	if L.Type(idx) != lua.LUA_TSTRING || !v.CanAddr() {
+		return false, nil
+	}
+	vp := v.Addr()
+	if !vp.CanInterface() || !vp.Type().Implements(ttextUnmarshaler) {
+		return false, nil
+	}
+	return true, vp.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(L.ToString(idx)))
+}
@@ -0,0 +1,92 @@
+package luar
+
+import (
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// TimeAsUnix controls how time.Time values are passed to Lua.
+//
+// If false (the default), they are converted to tables with the fields of
+// Lua's 'os.date("*t")' in local time: year, month, day, hour, min, sec, wday,
+// yday and isdst. Such tables can be passed to 'os.time'.
+//
+// If true, they are converted to Unix timestamps in seconds.
+//
+// LuaToGo accepts both representations regardless of this setting.
+var TimeAsUnix = false
+
+var ttime = typeof((*time.Time)(nil))
+
+func pushTime(L *lua.State, t time.Time) {
+	if TimeAsUnix {
+		// UnixNano overflows outside of the years 1678 to 2262, e.g. for the zero
+		// time.
+		if t.Nanosecond() == 0 {
+			L.PushInteger(t.Unix())
+		} else {
+			L.PushNumber(float64(t.Unix()) + float64(t.Nanosecond())/1e9)
+		}
+		return
+	}
+	t = t.Local()
+	L.CreateTable(0, 9)
+	fields := []struct {
+		name  string
+		value int
+	}{
+		{"year", t.Year()},
+		{"month", int(t.Month())},
+		{"day", t.Day()},
+		{"hour", t.Hour()},
+		{"min", t.Minute()},
+		{"sec", t.Second()},
+		{"wday", int(t.Weekday()) + 1},
+		{"yday", t.YearDay()},
+	}
+	for _, f := range fields {
+		L.PushInteger(int64(f.value))
+		L.SetField(-2, f.name)
+	}
+	L.PushBoolean(t.IsDST())
+	L.SetField(-2, "isdst")
+}
+
+// luaToTime sets 'v' to the time represented by the Lua number or table at
+// 'idx'. See TimeAsUnix.
+//
+// Missing hour, min and sec fields default to 0.
+func luaToTime(L *lua.State, idx int, v reflect.Value) error {
+	if L.Type(idx) == lua.LUA_TNUMBER {
+		sec, frac := math.Modf(L.ToNumber(idx))
+		v.Set(reflect.ValueOf(time.Unix(int64(sec), int64(frac*1e9))))
+		return nil
+	}
+
+	field := func(name string, required bool) (int, bool) {
+		L.GetField(idx, name)
+		defer L.Pop(1)
+		if L.IsNil(-1) {
+			return 0, !required
+		}
+		if !L.IsNumber(-1) {
+			return 0, false
+		}
+		return L.ToInteger(-1), true
+	}
+
+	var date [6]int
+	for i, name := range [...]string{"year", "month", "day", "hour", "min", "sec"} {
+		var ok bool
+		date[i], ok = field(name, i < 3)
+		if !ok {
+			return ConvError{From: luaDesc(L, idx), To: v.Type()}
+		}
+	}
+	t := time.Date(date[0], time.Month(date[1]), date[2], date[3], date[4], date[5], 0, time.Local)
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
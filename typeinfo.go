@@ -0,0 +1,230 @@
+package luar
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldIndex maps the Lua names of struct fields to their index sequence as
+// used by reflect.Value.FieldByIndex.
+type fieldIndex map[string][]int
+
+func indexLess(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// typeInfo caches the reflection lookups done on every proxy access.
+type typeInfo struct {
+	fields fieldIndex
+	// folded indexes the fields by lower-case name. See FoldFieldNames.
+	folded  fieldIndex
+	methods map[string]int
+	// rest is the index of the field holding the sequence part of tables, if
+	// any. See restField.
+	rest []int
+}
+
+var (
+	typeCache   = map[reflect.Type]*typeInfo{}
+	typeCacheMu sync.RWMutex
+)
+
+// fieldNamer gives the Lua name of untagged struct fields. See SetFieldNamer.
+var fieldNamer func(string) string
+
+// SetFieldNamer sets the function giving the Lua name of a struct field from its
+// Go name, e.g. to apply a snake_case policy to all structs. It applies both to
+// struct proxies and to conversions. Fields with a "lua" tag keep the tag name.
+// A nil function, the default, keeps the Go names.
+//
+// SetFieldNamer must not be called while values are being converted.
+func SetFieldNamer(f func(string) string) {
+	typeCacheMu.Lock()
+	fieldNamer = f
+	typeCache = map[reflect.Type]*typeInfo{}
+	typeCacheMu.Unlock()
+}
+
+// getTypeInfo returns the cached information about 't', computing it on first
+// use. The result must not be modified.
+func getTypeInfo(t reflect.Type) *typeInfo {
+	typeCacheMu.RLock()
+	info, ok := typeCache[t]
+	typeCacheMu.RUnlock()
+	if ok {
+		return info
+	}
+
+	info = &typeInfo{methods: map[string]int{}}
+	for i := 0; i < t.NumMethod(); i++ {
+		info.methods[t.Method(i).Name] = i
+	}
+	if t.Kind() == reflect.Struct {
+		info.fields = newFieldIndex(t)
+		info.folded = newFoldedIndex(info.fields)
+		info.rest = newRestField(t)
+	}
+
+	typeCacheMu.Lock()
+	typeCache[t] = info
+	typeCacheMu.Unlock()
+	return info
+}
+
+// structFields returns the fields of the struct type 't' visible from Lua. See
+// newFieldIndex.
+func structFields(t reflect.Type) fieldIndex {
+	return getTypeInfo(t).fields
+}
+
+// lookupField returns the index sequence of the field of the struct type 't'
+// matching the table key 'name'. See FoldFieldNames.
+func lookupField(t reflect.Type, name string) ([]int, bool) {
+	info := getTypeInfo(t)
+	if index, ok := info.fields[name]; ok {
+		return index, true
+	}
+	if !FoldFieldNames {
+		return nil, false
+	}
+	index, ok := info.folded[strings.ToLower(name)]
+	return index, ok
+}
+
+// restField returns the index of the field of the struct type 't' holding the
+// sequence part of tables, or nil if there is none. See newRestField.
+func restField(t reflect.Type) []int {
+	return getTypeInfo(t).rest
+}
+
+// typeMethods returns the index of the methods of 't' by name, as used by
+// reflect.Value.Method.
+func typeMethods(t reflect.Type) map[string]int {
+	return getTypeInfo(t).methods
+}
+
+// newFieldIndex returns the fields of the struct type 't' visible from Lua.
+//
+// Unexported fields are ignored. The "lua" tag sets the Lua name of a field,
+// while the "-" tag hides it. Other fields are named by fieldNamer if set. Tags
+// have priority over field names: if a tag collides with the name of another
+// field, the tagged field is the one that gets accessed. When several fields end
+// up with the same name, the first one wins.
+//
+// The fields of untagged anonymous structs, or pointers to structs, are
+// promoted as in Go: a field declared at a shallower depth hides the deeper
+// ones.
+func newFieldIndex(t reflect.Type) fieldIndex {
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+
+	fields := fieldIndex{}
+	seen := map[reflect.Type]bool{t: true}
+	level := []embedded{{t: t}}
+	for len(level) > 0 {
+		var next []embedded
+		for _, e := range level {
+			// Tagged fields first so that they have priority.
+			for _, tagged := range [...]bool{true, false} {
+				for i := 0; i < e.t.NumField(); i++ {
+					field := e.t.Field(i)
+					index := append(e.index[:len(e.index):len(e.index)], i)
+					name, _ := parseTag(field.Tag.Get("lua"))
+					if name == "" && !tagged && field.Anonymous {
+						ft := field.Type
+						if ft.Kind() == reflect.Ptr {
+							ft = ft.Elem()
+						}
+						if ft.Kind() == reflect.Struct && !seen[ft] {
+							seen[ft] = true
+							next = append(next, embedded{t: ft, index: index})
+						}
+					}
+					if field.PkgPath != "" {
+						continue
+					}
+					if name == "-" || (name != "") != tagged {
+						continue
+					}
+					if name == "" {
+						name = field.Name
+						if fieldNamer != nil {
+							name = fieldNamer(name)
+						}
+					}
+					if _, ok := fields[name]; !ok {
+						fields[name] = index
+					}
+				}
+			}
+		}
+		level = next
+	}
+	return fields
+}
+
+// newFoldedIndex returns 'fields' indexed by lower-case name. Among the fields
+// with the same lower-case name, the one declared first wins.
+func newFoldedIndex(fields fieldIndex) fieldIndex {
+	folded := fieldIndex{}
+	for name, index := range fields {
+		key := strings.ToLower(name)
+		if other, ok := folded[key]; !ok || indexLess(index, other) {
+			folded[key] = index
+		}
+	}
+	return folded
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex but it returns false instead
+// of panicking when it goes through a nil pointer to an embedded struct. If
+// 'alloc' is true, such pointers are allocated instead when possible.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// parseTag splits a "lua" tag into the field name and the option following the
+// comma, if any.
+func parseTag(tag string) (name, option string) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// newRestField returns the index of the first exported slice field of the
+// struct type 't' with the "rest" tag option, e.g. `lua:",rest"`. Such a field
+// holds the elements 1 to n of the sequence part of tables, while the other
+// keys map to fields as usual.
+func newRestField(t reflect.Type) []int {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Type.Kind() != reflect.Slice {
+			continue
+		}
+		if _, option := parseTag(field.Tag.Get("lua")); option == "rest" {
+			return field.Index
+		}
+	}
+	return nil
+}
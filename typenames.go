@@ -0,0 +1,94 @@
+package luar
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// typeField is the table field naming the Go type of a table, see
+// RegisterTypeName.
+const typeField = "__type"
+
+var (
+	typeNames   = map[string]reflect.Type{}
+	typeNamesMu sync.RWMutex
+)
+
+// RegisterTypeName lets LuaToGo decode tables into interfaces: a table whose
+// "__type" field is 'name' is converted to a new value of the type of 'proto'
+// when the destination is an interface, e.g. a Go function parameter of an
+// interface type. Lua code can then pass {__type = "person", Name = "foo"} where
+// a Go value implementing the interface is expected.
+//
+// If the type does not implement the interface but a pointer to it does, e.g.
+// because of pointer receivers, a pointer to the new value is stored. Otherwise
+// a ConvError is returned. The "__type" field itself does not count as an
+// unknown field for StrictStructs.
+//
+// Tables without "__type" field, or naming an unregistered type, are converted
+// as usual.
+//
+// If 'proto' is a pointer, the pointed type is registered. A nil 'proto'
+// removes the name. Unlike RegisterType, names are global to all states.
+//
+// RegisterTypeName can be called concurrently with conversions, which then
+// use either the previous or the new registration.
+func RegisterTypeName(name string, proto interface{}) {
+	typeNamesMu.Lock()
+	if proto == nil {
+		delete(typeNames, name)
+	} else {
+		t := reflect.TypeOf(proto)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		typeNames[name] = t
+	}
+	typeNamesMu.Unlock()
+}
+
+// typeNamed returns the type registered as 'name'.
+func typeNamed(name string) (reflect.Type, bool) {
+	typeNamesMu.RLock()
+	t, ok := typeNames[name]
+	typeNamesMu.RUnlock()
+	return t, ok
+}
+
+// copyTableToNamedType sets the interface 'v' to a new value of the type named
+// by the "__type" field of the table at 'idx'. It returns false if the field
+// does not name a registered type.
+func copyTableToNamedType(L *lua.State, idx int, v reflect.Value, visited map[uintptr]reflect.Value) (bool, error) {
+	if idx < 0 && idx > lua.LUA_REGISTRYINDEX {
+		idx = L.GetTop() + idx + 1
+	}
+	L.PushString(typeField)
+	L.RawGet(idx)
+	isString := L.Type(-1) == lua.LUA_TSTRING
+	name := L.ToString(-1)
+	L.Pop(1)
+	if !isString {
+		return false, nil
+	}
+	t, ok := typeNamed(name)
+	if !ok {
+		return false, nil
+	}
+
+	vp := reflect.New(t)
+	var val reflect.Value
+	switch {
+	case t.Implements(v.Type()):
+		val = vp.Elem()
+	case vp.Type().Implements(v.Type()):
+		val = vp
+	default:
+		return true, ConvError{From: luaDesc(L, idx), To: v.Type()}
+	}
+	// Like the other tables, partial conversions are kept.
+	err := luaToGo(L, idx, vp.Elem(), visited)
+	v.Set(val)
+	return true, err
+}